@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"fmt"
+	"net/http"
+	"strings"
 
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/admin"
 	"github.com/danilotorchio/go-expert-rate-limiter/internal/config"
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/metrics"
 	"github.com/danilotorchio/go-expert-rate-limiter/internal/middleware"
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/tracing"
 	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func main() {
@@ -17,34 +28,218 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize Redis storage
-	storage, err := ratelimiter.NewRedisStorage(
-		cfg.Redis.Host,
-		cfg.Redis.Port,
-		cfg.Redis.Password,
-		cfg.Redis.DB,
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize Redis storage: %v", err)
+	// Initialize storage
+	var storage ratelimiter.Storage
+	switch cfg.Storage.Type {
+	case config.StorageMemory:
+		storage = ratelimiter.NewMemoryStorage(cfg.Storage.CleanupInterval)
+	default:
+		storage, err = ratelimiter.NewRedisStorageWithOptions(ratelimiter.RedisOptions{
+			Mode:             cfg.Redis.Mode,
+			Addrs:            cfg.Redis.Addrs,
+			MasterName:       cfg.Redis.MasterName,
+			Username:         cfg.Redis.Username,
+			Password:         cfg.Redis.Password,
+			SentinelUsername: cfg.Redis.SentinelUsername,
+			SentinelPassword: cfg.Redis.SentinelPassword,
+			DB:               cfg.Redis.DB,
+			TLSConfig:        cfg.Redis.TLS,
+			PoolSize:         cfg.Redis.PoolSize,
+			DialTimeout:      cfg.Redis.DialTimeout,
+			ReadTimeout:      cfg.Redis.ReadTimeout,
+			WriteTimeout:     cfg.Redis.WriteTimeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize Redis storage: %v", err)
+		}
+	}
+	
+	// The blocked keys gauge lists the keys in the background, away from
+	// the circuit breaker and the per-call timeout of the requests
+	baseStorage := storage
+	
+	// The token registry shares tokens and the near cache invalidates blocks
+	// through the storage when it supports it
+	var tokenStore ratelimiter.TokenStore
+	if store, ok := storage.(ratelimiter.TokenStore); ok {
+		tokenStore = store
+	}
+	var invalidationBus ratelimiter.InvalidationBus
+	if bus, ok := storage.(ratelimiter.InvalidationBus); ok {
+		invalidationBus = bus
+	}
+	
+	// Guard Redis with a circuit breaker so that an outage follows the
+	// failure policy instead of failing every request
+	if cfg.Storage.Type == config.StorageRedis {
+		breakerConfig := ratelimiter.CircuitBreakerConfig{
+			Timeout:          cfg.Storage.Timeout,
+			FailureThreshold: cfg.Storage.BreakerFailures,
+			OpenDuration:     cfg.Storage.BreakerOpenDuration,
+		}
+		if cfg.Storage.FailurePolicy == ratelimiter.FailLocal {
+			breakerConfig.Fallback = ratelimiter.NewMemoryStorage(cfg.Storage.CleanupInterval)
+		}
+		storage = ratelimiter.NewCircuitBreakerStorage(storage, breakerConfig)
 	}
 	defer storage.Close()
 
+	// Initialize tracing, with a child span for every storage call
+	tracerProvider, shutdownTracing, err := tracing.NewProvider(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.OTLPEndpoint,
+		Insecure:    cfg.Tracing.OTLPInsecure,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+	
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.Tracing.Exporter != tracing.ExporterNone {
+		storage = tracing.InstrumentStorage(storage, tracerProvider, cfg.Storage.Type)
+	}
+
+	// Initialize metrics, recording the latency of every storage call
+	var limiterMetrics *metrics.Metrics
+	registry := prometheus.NewRegistry()
+	if cfg.Metrics.Enabled {
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		limiterMetrics = metrics.New(registry)
+		storage = metrics.InstrumentStorage(storage, limiterMetrics)
+		blockedKeys := metrics.RegisterBlockedKeys(registry, baseStorage, cfg.Metrics.BlockedKeysInterval)
+		defer blockedKeys.Close()
+	}
+
+	// Remember blocked keys in memory, in front of the instrumentation so
+	// that the requests it answers are not counted as storage calls
+	if cfg.Storage.NearCache && cfg.Storage.Type == config.StorageRedis {
+		storage = ratelimiter.NewNearCacheStorage(storage, invalidationBus)
+	}
+
+	// Initialize access list
+	accessList := ratelimiter.NewAccessList(cfg.Access.Allow, cfg.Access.Deny)
+	if cfg.Access.File != "" {
+		if err := accessList.WatchFile(cfg.Access.File, cfg.Access.ReloadInterval); err != nil {
+			log.Fatalf("Failed to load access list: %v", err)
+		}
+	}
+	defer accessList.Close()
+
+	// Initialize token registry, shared with the other replicas through
+	// Redis so that tokens can be managed at runtime
+	tokenRegistry, err := ratelimiter.NewManagedTokenRegistry(context.Background(), tokenStore, cfg.Tokens)
+	if err != nil {
+		log.Fatalf("Failed to initialize token registry: %v", err)
+	}
+	defer tokenRegistry.Close()
+
 	// Initialize rate limiter
 	limiterConfig := ratelimiter.Config{
-		DefaultIPLimit:    cfg.RateLimit.DefaultIPLimit,
-		DefaultTokenLimit: cfg.RateLimit.DefaultTokenLimit,
-		BlockDuration:     cfg.RateLimit.BlockDuration,
-		TokenLimits:       cfg.Tokens,
+		Algorithm:          cfg.RateLimit.Algorithm,
+		IdentityMode:       cfg.RateLimit.IdentityMode,
+		UnknownTokens:      cfg.RateLimit.UnknownTokens,
+		IPv4Prefix:         cfg.RateLimit.IPv4Prefix,
+		IPv6Prefix:         cfg.RateLimit.IPv6Prefix,
+		AccessList:         accessList,
+		DefaultIPLimits:    cfg.RateLimit.DefaultIPLimits,
+		DefaultTokenLimits: cfg.RateLimit.DefaultTokenLimits,
+		BlockDuration:      cfg.RateLimit.BlockDuration,
+		BlockEscalation:    cfg.RateLimit.BlockEscalation,
+		OffenceDecay:       cfg.RateLimit.OffenceDecay,
+		TokenRegistry:      tokenRegistry,
+		TracerProvider:     tracerProvider,
+	}
+	if limiterMetrics != nil {
+		limiterConfig.Observer = limiterMetrics
 	}
 
 	rateLimiter := ratelimiter.New(storage, limiterConfig)
 	defer rateLimiter.Close()
 
-	// Initialize Gin router
-	router := gin.Default()
+	// Initialize route policies, each with its own namespace on the shared storage
+	var policies []middleware.Policy
+	for _, policy := range cfg.Policies {
+		policyConfig := limiterConfig
+		policyConfig.DefaultIPLimits = policy.IPLimits
+		policyConfig.DefaultTokenLimits = policy.TokenLimits
+		// Tokens are still recognised within route policies but get the
+		// policy's token limits instead of their own
+		policyConfig.TokenRegistry = ratelimiter.KnownTokens{TokenRegistry: tokenRegistry}
+		policyConfig.Namespace = "policy:" + strings.ToLower(policy.Name)
+
+		policies = append(policies, middleware.Policy{
+			Method:  policy.Method,
+			Path:    policy.Path,
+			Limiter: ratelimiter.New(storage, policyConfig),
+			Exempt:  policy.Exempt,
+			Shadow:  policy.Shadow,
+		})
+	}
+
+	// Initialize Gin router, logging requests without the API tokens they carry
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
+
+	// Initialize admin API, registered before the rate limiter middleware so
+	// that support staff are never limited by the keys they manage
+	if cfg.Admin.Token != "" {
+		if cfg.Admin.Port != "" {
+			adminRouter := gin.New()
+			adminRouter.Use(middleware.Logger(), gin.Recovery())
+			admin.Register(adminRouter.Group(cfg.Admin.Prefix), rateLimiter, cfg.Admin.Token, admin.WithTokenRegistry(tokenRegistry))
+
+			go func() {
+				if err := adminRouter.Run(fmt.Sprintf(":%s", cfg.Admin.Port)); err != nil {
+					log.Fatalf("Failed to start admin server: %v", err)
+				}
+			}()
+		} else {
+			admin.Register(router.Group(cfg.Admin.Prefix), rateLimiter, cfg.Admin.Token, admin.WithTokenRegistry(tokenRegistry))
+		}
+	}
+
+	// Expose metrics, like the admin API outside the rate limiter
+	if cfg.Metrics.Enabled {
+		metricsHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		if cfg.Metrics.Port != "" {
+			mux := http.NewServeMux()
+			mux.Handle(cfg.Metrics.Path, metricsHandler)
+
+			go func() {
+				if err := http.ListenAndServe(fmt.Sprintf(":%s", cfg.Metrics.Port), mux); err != nil {
+					log.Fatalf("Failed to start metrics server: %v", err)
+				}
+			}()
+		} else {
+			router.GET(cfg.Metrics.Path, gin.WrapH(metricsHandler))
+		}
+	}
 
 	// Apply rate limiter middleware
-	router.Use(middleware.RateLimiterMiddleware(rateLimiter))
+	middlewareOptions := []middleware.Option{
+		middleware.WithPolicies(policies...),
+		middleware.WithTracerProvider(tracerProvider),
+		middleware.WithPropagator(otel.GetTextMapPropagator()),
+		middleware.WithIPResolver(middleware.IPResolver{
+			TrustedProxies: cfg.Server.TrustedProxies,
+			Header:         cfg.Server.TrustedProxyHeader,
+			IgnoreHeaders:  cfg.Server.IgnoreForwardedHeaders,
+		}),
+	}
+	if cfg.Server.StandardHeaders {
+		middlewareOptions = append(middlewareOptions, middleware.WithStandardHeaders())
+	}
+	if cfg.Server.Shadow {
+		middlewareOptions = append(middlewareOptions, middleware.WithShadowMode())
+	}
+	if cfg.Storage.FailurePolicy == ratelimiter.FailOpen {
+		middlewareOptions = append(middlewareOptions, middleware.WithFailOpen())
+	}
+	router.Use(middleware.RateLimiterMiddleware(rateLimiter, middlewareOptions...))
 
 	// Add some example routes
 	router.GET("/", func(c *gin.Context) {
@@ -69,14 +264,73 @@ func main() {
 	// Start server
 	log.Printf("Starting server on port %s", cfg.Server.Port)
 	log.Printf("Rate limiter configuration:")
-	log.Printf("- Default IP limit: %d req/s", cfg.RateLimit.DefaultIPLimit)
-	log.Printf("- Default token limit: %d req/s", cfg.RateLimit.DefaultTokenLimit)
+	log.Printf("- Storage: %s", cfg.Storage.Type)
+	if cfg.Storage.Type == config.StorageRedis {
+		log.Printf("- Redis: %s %v (TLS %t)", cfg.Redis.Mode, cfg.Redis.Addrs, cfg.Redis.TLS != nil)
+		log.Printf("- Failure policy: %s (timeout %v, circuit opens after %d failures for %v)",
+			cfg.Storage.FailurePolicy, cfg.Storage.Timeout, cfg.Storage.BreakerFailures, cfg.Storage.BreakerOpenDuration)
+		if cfg.Storage.NearCache {
+			log.Printf("- Near cache: blocked keys are remembered until their blocks expire")
+		}
+	}
+	log.Printf("- Algorithm: %s", cfg.RateLimit.Algorithm)
+	log.Printf("- Identity mode: %s", cfg.RateLimit.IdentityMode)
+	log.Printf("- Unknown tokens: %s", cfg.RateLimit.UnknownTokens)
+	log.Printf("- IP prefixes: IPv4 /%d, IPv6 /%d", cfg.RateLimit.IPv4Prefix, cfg.RateLimit.IPv6Prefix)
+	log.Printf("- Default IP limits: %v", cfg.RateLimit.DefaultIPLimits)
+	log.Printf("- Default token limits: %v", cfg.RateLimit.DefaultTokenLimits)
 	log.Printf("- Block duration: %v", cfg.RateLimit.BlockDuration)
+	if cfg.Server.Shadow {
+		log.Printf("- Shadow mode: requests are never rejected for exceeding limits")
+	}
+	if len(cfg.RateLimit.BlockEscalation) > 0 {
+		log.Printf("- Block escalation: %v (offences decay after %v)", cfg.RateLimit.BlockEscalation, cfg.RateLimit.OffenceDecay)
+	}
+	log.Printf("- Trusted proxies: %v (header %s)", cfg.Server.TrustedProxies, cfg.Server.TrustedProxyHeader)
+	log.Printf("- Allowlist: %v, denylist: %v", cfg.Access.Allow, cfg.Access.Deny)
+	if cfg.Access.File != "" {
+		log.Printf("- Access list file: %s (reloaded every %v)", cfg.Access.File, cfg.Access.ReloadInterval)
+	}
+	
+	if cfg.Admin.Token != "" {
+		adminPort := cfg.Admin.Port
+		if adminPort == "" {
+			adminPort = cfg.Server.Port
+		}
+		log.Printf("- Admin API: port %s, prefix %s", adminPort, cfg.Admin.Prefix)
+	}
 	
 	if len(cfg.Tokens) > 0 {
 		log.Printf("- Token-specific limits:")
-		for token, limit := range cfg.Tokens {
-			log.Printf("  - %s: %d req/s", token, limit)
+		for token, limits := range cfg.Tokens {
+			log.Printf("  - %s: %v", token, limits)
+		}
+	}
+
+	if cfg.Metrics.Enabled {
+		metricsPort := cfg.Metrics.Port
+		if metricsPort == "" {
+			metricsPort = cfg.Server.Port
+		}
+		log.Printf("- Metrics: port %s, path %s", metricsPort, cfg.Metrics.Path)
+	}
+
+	if cfg.Tracing.Exporter != tracing.ExporterNone {
+		log.Printf("- Tracing: %s exporter, service %s, sample ratio %g", cfg.Tracing.Exporter, cfg.Tracing.ServiceName, cfg.Tracing.SampleRatio)
+	}
+
+	if tokenStore != nil {
+		log.Printf("- Managed tokens: %d, shared through Redis", len(tokenRegistry.Tokens())-len(cfg.Tokens))
+	}
+
+	if len(cfg.Policies) > 0 {
+		log.Printf("- Route policies:")
+		for _, policy := range cfg.Policies {
+			if policy.Exempt {
+				log.Printf("  - %s %s %s: exempt", policy.Name, policy.Method, policy.Path)
+				continue
+			}
+			log.Printf("  - %s %s %s: IP %v, token %v, shadow %t", policy.Name, policy.Method, policy.Path, policy.IPLimits, policy.TokenLimits, policy.Shadow)
 		}
 	}
 
@@ -0,0 +1,311 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/gin-gonic/gin"
+)
+
+// Register adds the admin endpoints to router, each requiring the header
+// "Authorization: Bearer <token>". Keys are identity keys as stored by the
+// limiter, such as "ip:192.168.1.1" or "token:abc123", passed in the key
+// query parameter.
+//
+//	GET    /keys?key=   current counts, block status and block TTL of a key
+//	DELETE /keys?key=   unblock a key and reset its counters
+//	GET    /blocks      list the blocked keys
+//	DELETE /blocks?key= unblock a key, keeping its counters
+//
+// With WithTokenRegistry, the API tokens can be managed as well:
+//
+//	GET    /tokens        list the tokens and their limits
+//	PUT    /tokens/:token create or update a token
+//	DELETE /tokens/:token revoke a token
+func Register(router gin.IRouter, limiter *ratelimiter.RateLimiter, token string, opts ...Option) {
+	h := &handler{limiter: limiter}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	group := router.Group("", authenticate(token))
+	group.GET("/keys", h.inspect)
+	group.DELETE("/keys", h.reset)
+	group.GET("/blocks", h.blockedKeys)
+	group.DELETE("/blocks", h.unblock)
+
+	if h.tokens != nil {
+		group.GET("/tokens", h.listTokens)
+		group.PUT("/tokens/:token", h.setToken)
+		group.DELETE("/tokens/:token", h.revokeToken)
+	}
+}
+
+// Option configures the admin API
+type Option func(*handler)
+
+// WithTokenRegistry adds the endpoints managing the tokens of registry
+func WithTokenRegistry(registry *ratelimiter.ManagedTokenRegistry) Option {
+	return func(h *handler) {
+		h.tokens = registry
+	}
+}
+
+// authenticate rejects requests without the admin bearer token
+func authenticate(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+
+	return func(c *gin.Context) {
+		provided := []byte(c.GetHeader("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(provided, expected) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "unauthorized",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+type handler struct {
+	limiter *ratelimiter.RateLimiter
+	tokens  *ratelimiter.ManagedTokenRegistry
+}
+
+type keyState struct {
+	Key             string        `json:"key"`
+	Blocked         bool          `json:"blocked"`
+	BlockTTLSeconds *int64        `json:"block_ttl_seconds,omitempty"`
+	Windows         []windowCount `json:"windows"`
+}
+
+type windowCount struct {
+	Limit         int       `json:"limit"`
+	WindowSeconds int64     `json:"window_seconds"`
+	Count         int64     `json:"count"`
+	Reset         time.Time `json:"reset"`
+}
+
+type blockedKey struct {
+	Key        string `json:"key"`
+	TTLSeconds *int64 `json:"ttl_seconds,omitempty"`
+}
+
+func (h *handler) inspect(c *gin.Context) {
+	key, ok := requireKey(c)
+	if !ok {
+		return
+	}
+
+	state, err := h.limiter.Inspect(c.Request.Context(), key)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	response := keyState{
+		Key:             state.Key,
+		Blocked:         state.Blocked,
+		BlockTTLSeconds: ttlSeconds(state.BlockTTL),
+		Windows:         make([]windowCount, len(state.Windows)),
+	}
+	for i, window := range state.Windows {
+		response.Windows[i] = windowCount{
+			Limit:         window.Limit.Requests,
+			WindowSeconds: int64(window.Limit.Window / time.Second),
+			Count:         window.Count,
+			Reset:         window.ResetTime.UTC(),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *handler) reset(c *gin.Context) {
+	key, ok := requireKey(c)
+	if !ok {
+		return
+	}
+
+	if err := h.limiter.Reset(c.Request.Context(), key); err != nil {
+		internalError(c, err)
+		return
+	}
+
+	log.Printf("Admin: reset %s", ratelimiter.RedactKey(key))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handler) blockedKeys(c *gin.Context) {
+	keys, err := h.limiter.BlockedKeys(c.Request.Context())
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+
+	response := make([]blockedKey, len(keys))
+	for i, key := range keys {
+		response[i] = blockedKey{Key: key.Key, TTLSeconds: ttlSeconds(key.TTL)}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocked": response,
+	})
+}
+
+func (h *handler) unblock(c *gin.Context) {
+	key, ok := requireKey(c)
+	if !ok {
+		return
+	}
+
+	if err := h.limiter.Unblock(c.Request.Context(), key); err != nil {
+		internalError(c, err)
+		return
+	}
+
+	log.Printf("Admin: unblocked %s", ratelimiter.RedactKey(key))
+	c.Status(http.StatusNoContent)
+}
+
+// tokenLimits is the JSON form of the limits of a token, in the format of
+// the TOKEN_<name>_LIMIT and TOKEN_<name>_BURST variables. Empty limits
+// mean the default token limits.
+type tokenLimits struct {
+	Token  string `json:"token,omitempty"`
+	Limits string `json:"limits"`
+	Burst  int    `json:"burst,omitempty"`
+	Static bool   `json:"static,omitempty"`
+}
+
+func newTokenLimits(token string, limits []ratelimiter.Limit, static bool) tokenLimits {
+	response := tokenLimits{Token: token, Static: static}
+
+	parts := make([]string, len(limits))
+	for i, limit := range limits {
+		parts[i] = limit.String()
+	}
+	response.Limits = strings.Join(parts, ",")
+	if len(limits) > 0 {
+		response.Burst = limits[0].Burst
+	}
+
+	return response
+}
+
+func (h *handler) listTokens(c *gin.Context) {
+	tokens := h.tokens.Tokens()
+
+	response := make([]tokenLimits, len(tokens))
+	for i, token := range tokens {
+		response[i] = newTokenLimits(token.Token, token.Limits, token.Static)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": response,
+	})
+}
+
+func (h *handler) setToken(c *gin.Context) {
+	token := c.Param("token")
+
+	var request tokenLimits
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid request body: " + err.Error(),
+		})
+		return
+	}
+
+	limits, err := ratelimiter.ParseTokenLimits(request.Limits, request.Burst)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.tokens.SetToken(c.Request.Context(), token, limits); err != nil {
+		tokenError(c, err)
+		return
+	}
+
+	log.Printf("Admin: set token %s to %v", ratelimiter.RedactToken(token), limits)
+	c.JSON(http.StatusOK, newTokenLimits(token, limits, false))
+}
+
+func (h *handler) revokeToken(c *gin.Context) {
+	token := c.Param("token")
+
+	existed, err := h.tokens.RevokeToken(c.Request.Context(), token)
+	if err != nil {
+		tokenError(c, err)
+		return
+	}
+	if !existed {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "unknown token",
+		})
+		return
+	}
+
+	log.Printf("Admin: revoked token %s", ratelimiter.RedactToken(token))
+	c.Status(http.StatusNoContent)
+}
+
+// tokenError responds to a failed token change
+func tokenError(c *gin.Context, err error) {
+	if errors.Is(err, ratelimiter.ErrStaticToken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "token is configured at startup and cannot be changed at runtime",
+		})
+		return
+	}
+
+	internalError(c, err)
+}
+
+// requireKey returns the key query parameter, responding with 400 when it is missing
+func requireKey(c *gin.Context) (string, bool) {
+	key := strings.TrimSpace(c.Query("key"))
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "missing key query parameter",
+		})
+		return "", false
+	}
+
+	return key, true
+}
+
+func internalError(c *gin.Context, err error) {
+	// The route template, as the path of the token endpoints holds the token
+	log.Printf("Admin request %s %s failed: %v", c.Request.Method, c.FullPath(), err)
+	if errors.Is(err, ratelimiter.ErrStorageUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "rate limiter unavailable",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": "Internal server error",
+	})
+}
+
+// ttlSeconds rounds a block TTL up to whole seconds. It returns nil for a
+// block without expiry and for no block at all.
+func ttlSeconds(ttl time.Duration) *int64 {
+	if ttl <= 0 {
+		return nil
+	}
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	return &seconds
+}
@@ -1,19 +1,63 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/netip"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/middleware"
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/tracing"
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
+	Storage  StorageConfig
+	Access   AccessConfig
 	Redis    RedisConfig
 	Server   ServerConfig
+	Admin    AdminConfig
+	Metrics  MetricsConfig
+	Tracing  TracingConfig
 	RateLimit RateLimitConfig
-	Tokens   map[string]int
+	Tokens   map[string][]ratelimiter.Limit
+	Policies []PolicyConfig
+}
+
+// Supported storage backends
+const (
+	StorageRedis  = "redis"
+	StorageMemory = "memory"
+)
+
+type StorageConfig struct {
+	Type            string
+	CleanupInterval time.Duration
+	
+	// FailurePolicy selects how requests are handled while Redis is
+	// unavailable, as detected by a circuit breaker with per-call timeouts
+	FailurePolicy       ratelimiter.FailurePolicy
+	Timeout             time.Duration
+	BreakerFailures     int
+	BreakerOpenDuration time.Duration
+	
+	// NearCache remembers blocked keys in memory until their blocks expire
+	NearCache bool
+}
+
+// AccessConfig holds the IP allowlist and denylist
+type AccessConfig struct {
+	Allow          []netip.Prefix
+	Deny           []netip.Prefix
+	File           string
+	ReloadInterval time.Duration
 }
 
 type RedisConfig struct {
@@ -21,74 +65,641 @@ type RedisConfig struct {
 	Port     string
 	Password string
 	DB       int
+	
+	// Mode selects a single node, Sentinel or Cluster. Addrs are the node,
+	// Sentinel or seed addresses, defaulting to Host:Port.
+	Mode             ratelimiter.RedisMode
+	Addrs            []string
+	MasterName       string
+	Username         string
+	SentinelUsername string
+	SentinelPassword string
+	
+	// TLS is nil unless REDIS_TLS is set
+	TLS *tls.Config
+	
+	// Zero values keep the go-redis defaults
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
 type ServerConfig struct {
 	Port string
+	
+	// TrustedProxies are the proxies whose forwarding headers are believed
+	TrustedProxies         []netip.Prefix
+	IgnoreForwardedHeaders bool
+	
+	// TrustedProxyHeader is the only forwarding header read, the one the
+	// trusted proxies write
+	TrustedProxyHeader string
+	
+	// StandardHeaders adds the IETF RateLimit-Policy and RateLimit headers
+	StandardHeaders bool
+	
+	// Shadow lets every request through, only logging would-be rejections
+	Shadow bool
+}
+
+// AdminConfig holds the admin API settings. An empty Token disables the API,
+// and an empty Port serves it under Prefix on the main server.
+type AdminConfig struct {
+	Token  string
+	Port   string
+	Prefix string
+}
+
+// MetricsConfig holds the Prometheus metrics settings. An empty Port
+// serves them at Path on the main server.
+type MetricsConfig struct {
+	Enabled bool
+	Port    string
+	Path    string
+
+	// BlockedKeysInterval is how often the blocked keys gauge lists the keys
+	BlockedKeysInterval time.Duration
+}
+
+// TracingConfig holds the OpenTelemetry tracing settings. The none exporter
+// disables tracing.
+type TracingConfig struct {
+	Exporter     string
+	OTLPEndpoint string
+	OTLPInsecure bool
+	ServiceName  string
+	SampleRatio  float64
 }
 
 type RateLimitConfig struct {
-	DefaultIPLimit    int
-	DefaultTokenLimit int
-	BlockDuration     time.Duration
+	Algorithm         ratelimiter.Algorithm
+	IdentityMode      ratelimiter.IdentityMode
+	UnknownTokens     ratelimiter.UnknownTokenPolicy
+	IPv4Prefix        int
+	IPv6Prefix        int
+	DefaultIPLimits    []ratelimiter.Limit
+	DefaultTokenLimits []ratelimiter.Limit
+	BlockDuration      time.Duration
+	BlockEscalation    []time.Duration
+	OffenceDecay       time.Duration
+}
+
+// PolicyConfig describes the limits of one route, set through the
+// POLICY_<name>_* variables
+type PolicyConfig struct {
+	Name        string
+	Method      string
+	Path        string
+	IPLimits    []ratelimiter.Limit
+	TokenLimits []ratelimiter.Limit
+	Exempt      bool
+	Shadow      bool
 }
 
 func Load() (*Config, error) {
 	// Load .env file if exists
 	godotenv.Load()
 
-	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
-	defaultIPLimit, _ := strconv.Atoi(getEnv("DEFAULT_IP_LIMIT", "10"))
-	defaultTokenLimit, _ := strconv.Atoi(getEnv("DEFAULT_TOKEN_LIMIT", "100"))
-	blockDurationSeconds, _ := strconv.Atoi(getEnv("BLOCK_DURATION_SECONDS", "300"))
+	// Load the configuration file if set, which the environment overrides
+	l, err := newLoader(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	redisDB, err := l.getInt("REDIS_DB", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	redisPort, err := l.getPort("REDIS_PORT", "6379")
+	if err != nil {
+		return nil, err
+	}
+	
+	redisMode, err := ratelimiter.ParseRedisMode(strings.ToLower(l.get("REDIS_MODE", string(ratelimiter.RedisStandalone))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("REDIS_MODE"), err)
+	}
+	
+	redisAddrs := splitList(l.get("REDIS_ADDRS", ""))
+	if len(redisAddrs) == 0 {
+		redisAddrs = []string{fmt.Sprintf("%s:%s", l.get("REDIS_HOST", "localhost"), redisPort)}
+	}
+	
+	redisMasterName := l.get("REDIS_MASTER_NAME", "")
+	if redisMode == ratelimiter.RedisSentinel && redisMasterName == "" {
+		return nil, fmt.Errorf("%s is required with Redis Sentinel", l.name("REDIS_MASTER_NAME"))
+	}
+	
+	redisTLS, err := l.redisTLS()
+	if err != nil {
+		return nil, err
+	}
+	
+	redisPoolSize, err := l.getInt("REDIS_POOL_SIZE", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	redisDialTimeoutMs, err := l.getInt("REDIS_DIAL_TIMEOUT_MS", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	redisReadTimeoutMs, err := l.getInt("REDIS_READ_TIMEOUT_MS", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	redisWriteTimeoutMs, err := l.getInt("REDIS_WRITE_TIMEOUT_MS", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	serverPort, err := l.getPort("SERVER_PORT", "8080")
+	if err != nil {
+		return nil, err
+	}
+	
+	adminPort, err := l.getPort("ADMIN_PORT", "")
+	if err != nil {
+		return nil, err
+	}
+	
+	metricsEnabled, err := l.getBool("METRICS_ENABLED", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	metricsPort, err := l.getPort("METRICS_PORT", "")
+	if err != nil {
+		return nil, err
+	}
+	
+	blockedKeysIntervalSeconds, err := l.getInt("METRICS_BLOCKED_KEYS_INTERVAL_SECONDS", "30", 1)
+	if err != nil {
+		return nil, err
+	}
+	
+	storageTimeoutMs, err := l.getInt("STORAGE_TIMEOUT_MS", "100", 1)
+	if err != nil {
+		return nil, err
+	}
+	
+	breakerFailures, err := l.getInt("CIRCUIT_BREAKER_FAILURES", "5", 1)
+	if err != nil {
+		return nil, err
+	}
+	
+	breakerOpenSeconds, err := l.getInt("CIRCUIT_BREAKER_OPEN_SECONDS", "10", 1)
+	if err != nil {
+		return nil, err
+	}
+	
+	nearCache, err := l.getBool("NEAR_CACHE_ENABLED", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	failurePolicy, err := ratelimiter.ParseFailurePolicy(strings.ToLower(l.get("FAILURE_POLICY", string(ratelimiter.FailClosed))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("FAILURE_POLICY"), err)
+	}
+	
+	tracingExporter := strings.ToLower(l.get("TRACING_EXPORTER", tracing.ExporterNone))
+	switch tracingExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be %q, %q or %q", l.name("TRACING_EXPORTER"), tracingExporter,
+			tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	}
+	
+	tracingInsecure, err := l.getBool("TRACING_OTLP_INSECURE", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	tracingSampleRatio, err := l.getRatio("TRACING_SAMPLE_RATIO", "1")
+	if err != nil {
+		return nil, err
+	}
+	
+	defaultIPBurst, err := l.getInt("DEFAULT_IP_BURST", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	defaultTokenBurst, err := l.getInt("DEFAULT_TOKEN_BURST", "0", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	blockDurationSeconds, err := l.getInt("BLOCK_DURATION_SECONDS", "300", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	cleanupIntervalSeconds, err := l.getInt("MEMORY_CLEANUP_INTERVAL_SECONDS", "60", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := ratelimiter.ParsePrefixes(l.get("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("TRUSTED_PROXIES"), err)
+	}
+	
+	ignoreForwardedHeaders, err := l.getBool("IGNORE_FORWARDED_HEADERS", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	trustedProxyHeader := strings.ToLower(l.get("TRUSTED_PROXY_HEADER", middleware.HeaderXForwardedFor))
+	switch trustedProxyHeader {
+	case middleware.HeaderXForwardedFor, middleware.HeaderForwarded, middleware.HeaderXRealIP:
+	default:
+		return nil, fmt.Errorf("invalid %s %q: must be %q, %q or %q", l.name("TRUSTED_PROXY_HEADER"), trustedProxyHeader,
+			middleware.HeaderXForwardedFor, middleware.HeaderForwarded, middleware.HeaderXRealIP)
+	}
+	
+	blockEscalation, err := parseDurations(l.get("BLOCK_ESCALATION", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("BLOCK_ESCALATION"), err)
+	}
+	
+	offenceDecaySeconds, err := l.getInt("OFFENCE_DECAY_SECONDS", "86400", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	standardHeaders, err := l.getBool("RATE_LIMIT_STANDARD_HEADERS", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	shadow, err := l.getBool("RATE_LIMIT_SHADOW", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	allowlist, err := ratelimiter.ParsePrefixes(l.get("ALLOWLIST", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("ALLOWLIST"), err)
+	}
+	
+	denylist, err := ratelimiter.ParsePrefixes(l.get("DENYLIST", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("DENYLIST"), err)
+	}
+	
+	accessListReloadSeconds, err := l.getInt("ACCESS_LIST_RELOAD_SECONDS", "10", 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	storageType := strings.ToLower(l.get("STORAGE_TYPE", StorageRedis))
+	if storageType != StorageRedis && storageType != StorageMemory {
+		return nil, fmt.Errorf("invalid %s %q: must be %q or %q", l.name("STORAGE_TYPE"), storageType, StorageRedis, StorageMemory)
+	}
+
+	algorithm, err := ratelimiter.ParseAlgorithm(strings.ToLower(l.get("RATE_LIMIT_ALGORITHM", string(ratelimiter.FixedWindow))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("RATE_LIMIT_ALGORITHM"), err)
+	}
+
+	identityMode, err := ratelimiter.ParseIdentityMode(strings.ToLower(l.get("IDENTITY_MODE", string(ratelimiter.IdentityToken))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("IDENTITY_MODE"), err)
+	}
+
+	unknownTokens, err := ratelimiter.ParseUnknownTokenPolicy(strings.ToLower(l.get("UNKNOWN_TOKEN_POLICY", string(ratelimiter.UnknownTokenDefault))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("UNKNOWN_TOKEN_POLICY"), err)
+	}
+
+	ipv4Prefix, err := l.prefixLength("IPV4_PREFIX_LENGTH", "32", 32)
+	if err != nil {
+		return nil, err
+	}
+	
+	ipv6Prefix, err := l.prefixLength("IPV6_PREFIX_LENGTH", "64", 128)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultIPLimits, err := ratelimiter.ParseLimits(l.get("DEFAULT_IP_LIMIT", "10/s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("DEFAULT_IP_LIMIT"), err)
+	}
+	defaultIPLimits[0].Burst = defaultIPBurst
+
+	defaultTokenLimits, err := ratelimiter.ParseLimits(l.get("DEFAULT_TOKEN_LIMIT", "100/s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", l.name("DEFAULT_TOKEN_LIMIT"), err)
+	}
+	defaultTokenLimits[0].Burst = defaultTokenBurst
+
+	tokens, err := l.tokenLimits(defaultTokenLimits)
+	if err != nil {
+		return nil, err
+	}
+	
+	policies, err := l.policies(defaultIPLimits, defaultTokenLimits)
+	if err != nil {
+		return nil, err
+	}
 
 	cfg := &Config{
+		Storage: StorageConfig{
+			Type:            storageType,
+			CleanupInterval: time.Duration(cleanupIntervalSeconds) * time.Second,
+			FailurePolicy:       failurePolicy,
+			Timeout:             time.Duration(storageTimeoutMs) * time.Millisecond,
+			BreakerFailures:     breakerFailures,
+			BreakerOpenDuration: time.Duration(breakerOpenSeconds) * time.Second,
+			NearCache:           nearCache,
+		},
+		Access: AccessConfig{
+			Allow:          allowlist,
+			Deny:           denylist,
+			File:           l.get("ACCESS_LIST_FILE", ""),
+			ReloadInterval: time.Duration(accessListReloadSeconds) * time.Second,
+		},
 		Redis: RedisConfig{
-			Host:     getEnv("REDIS_HOST", "localhost"),
-			Port:     getEnv("REDIS_PORT", "6379"),
-			Password: getEnv("REDIS_PASSWORD", ""),
+			Host:     l.get("REDIS_HOST", "localhost"),
+			Port:     redisPort,
+			Password: l.get("REDIS_PASSWORD", ""),
 			DB:       redisDB,
+			Mode:             redisMode,
+			Addrs:            redisAddrs,
+			MasterName:       redisMasterName,
+			Username:         l.get("REDIS_USERNAME", ""),
+			SentinelUsername: l.get("REDIS_SENTINEL_USERNAME", ""),
+			SentinelPassword: l.get("REDIS_SENTINEL_PASSWORD", ""),
+			TLS:              redisTLS,
+			PoolSize:         redisPoolSize,
+			DialTimeout:      time.Duration(redisDialTimeoutMs) * time.Millisecond,
+			ReadTimeout:      time.Duration(redisReadTimeoutMs) * time.Millisecond,
+			WriteTimeout:     time.Duration(redisWriteTimeoutMs) * time.Millisecond,
 		},
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
+			Port:                   serverPort,
+			TrustedProxies:         trustedProxies,
+			IgnoreForwardedHeaders: ignoreForwardedHeaders,
+			TrustedProxyHeader:     trustedProxyHeader,
+			StandardHeaders:        standardHeaders,
+			Shadow:                 shadow,
+		},
+		Admin: AdminConfig{
+			Token:  l.get("ADMIN_TOKEN", ""),
+			Port:   adminPort,
+			Prefix: "/" + strings.Trim(l.get("ADMIN_PREFIX", "/admin"), "/"),
+		},
+		Metrics: MetricsConfig{
+			Enabled: metricsEnabled,
+			Port:    metricsPort,
+			Path:    "/" + strings.Trim(l.get("METRICS_PATH", "/metrics"), "/"),
+
+			BlockedKeysInterval: time.Duration(blockedKeysIntervalSeconds) * time.Second,
+		},
+		Tracing: TracingConfig{
+			Exporter:     tracingExporter,
+			OTLPEndpoint: l.get("TRACING_OTLP_ENDPOINT", ""),
+			OTLPInsecure: tracingInsecure,
+			ServiceName:  l.get("TRACING_SERVICE_NAME", "rate-limiter"),
+			SampleRatio:  tracingSampleRatio,
 		},
 		RateLimit: RateLimitConfig{
-			DefaultIPLimit:    defaultIPLimit,
-			DefaultTokenLimit: defaultTokenLimit,
+			Algorithm:         algorithm,
+			IdentityMode:      identityMode,
+			UnknownTokens:     unknownTokens,
+			IPv4Prefix:        ipv4Prefix,
+			IPv6Prefix:        ipv6Prefix,
+			DefaultIPLimits:    defaultIPLimits,
+			DefaultTokenLimits: defaultTokenLimits,
 			BlockDuration:     time.Duration(blockDurationSeconds) * time.Second,
+			BlockEscalation:    blockEscalation,
+			OffenceDecay:       time.Duration(offenceDecaySeconds) * time.Second,
 		},
-		Tokens: loadTokenConfig(),
+		Tokens: tokens,
+		Policies: policies,
 	}
 
 	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// parseDurations parses a comma-separated list of positive durations such as "10s,1m,5m,1h"
+func parseDurations(value string) ([]time.Duration, error) {
+	var durations []time.Duration
+	
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		
+		duration, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("duration %q must be positive", part)
+		}
+		durations = append(durations, duration)
+	}
+	
+	return durations, nil
+}
+
+// splitList parses a comma-separated list, skipping empty items
+func splitList(value string) []string {
+	var items []string
+	
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			items = append(items, part)
+		}
 	}
-	return defaultValue
+	
+	return items
 }
 
-func loadTokenConfig() map[string]int {
-	tokens := make(map[string]int)
+// redisTLS builds the TLS configuration of the Redis connection from
+// REDIS_TLS and the REDIS_TLS_* variables, or returns nil without TLS
+func (l *loader) redisTLS() (*tls.Config, error) {
+	enabled, err := l.getBool("REDIS_TLS", "false")
+	if err != nil || !enabled {
+		return nil, err
+	}
 	
-	for _, env := range os.Environ() {
-		pair := strings.SplitN(env, "=", 2)
-		if len(pair) != 2 {
-			continue
+	insecure, err := l.getBool("REDIS_TLS_INSECURE_SKIP_VERIFY", "false")
+	if err != nil {
+		return nil, err
+	}
+	
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         l.get("REDIS_TLS_SERVER_NAME", ""),
+		InsecureSkipVerify: insecure,
+	}
+	
+	// Without a CA file the system roots verify the server
+	if caFile := l.get("REDIS_TLS_CA_FILE", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", l.name("REDIS_TLS_CA_FILE"), err)
 		}
 		
-		key := pair[0]
-		value := pair[1]
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid %s %q: no PEM certificate found", l.name("REDIS_TLS_CA_FILE"), caFile)
+		}
+	}
+	
+	return config, nil
+}
+
+// prefixLength reads a network prefix length between 1 and maxBits from key
+func (l *loader) prefixLength(key, defaultValue string, maxBits int) (int, error) {
+	value := l.get(key, defaultValue)
+	
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 1 || bits > maxBits {
+		return 0, fmt.Errorf("invalid %s %q: must be between 1 and %d", l.name(key), value, maxBits)
+	}
+	
+	return bits, nil
+}
+
+// tokenLimits builds the token-specific limits from TOKEN_<name>_LIMIT
+// (e.g. "50", "50/s" or "10/s,1000/d") and TOKEN_<name>_BURST variables.
+// The burst applies to the first limit of the token. A token with only a
+// burst gets the default token limits with that burst.
+func (l *loader) tokenLimits(defaultLimits []ratelimiter.Limit) (map[string][]ratelimiter.Limit, error) {
+	tokens := make(map[string][]ratelimiter.Limit)
+	
+	for name, value := range l.prefixed("TOKEN_", "_LIMIT") {
+		limits, err := ratelimiter.ParseLimits(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", l.name("TOKEN_"+name+"_LIMIT"), err)
+		}
+		tokens[name] = limits
+	}
+	
+	for name, value := range l.prefixed("TOKEN_", "_BURST") {
+		burst, err := strconv.Atoi(value)
+		if err != nil || burst < 0 {
+			return nil, fmt.Errorf("invalid %s %q: must be a whole number of at least 0", l.name("TOKEN_"+name+"_BURST"), value)
+		}
+		
+		limits, exists := tokens[name]
+		if !exists {
+			limits = append([]ratelimiter.Limit(nil), defaultLimits...)
+		}
+		limits[0].Burst = burst
+		tokens[name] = limits
+	}
+	
+	return tokens, nil
+}
+
+// policies builds the route policies from POLICY_<name>_ROUTE (e.g.
+// "POST /api/data" or "/health" for any method), POLICY_<name>_IP_LIMIT,
+// POLICY_<name>_TOKEN_LIMIT, POLICY_<name>_EXEMPT and POLICY_<name>_SHADOW variables.
+// Every policy named by any of them, or in the file, must have a route.
+// Limits that are not set fall back to the defaults. Policies are sorted by
+// name so the result does not depend on the environment order.
+func (l *loader) policies(defaultIPLimits, defaultTokenLimits []ratelimiter.Limit) ([]PolicyConfig, error) {
+	ipLimits := l.prefixed("POLICY_", "_IP_LIMIT")
+	tokenLimits := l.prefixed("POLICY_", "_TOKEN_LIMIT")
+	exempt := l.prefixed("POLICY_", "_EXEMPT")
+	shadow := l.prefixed("POLICY_", "_SHADOW")
+	
+	routes := l.prefixed("POLICY_", "_ROUTE")
+	
+	// A policy whose route is missing would silently never apply
+	named := make(map[string]bool)
+	for name := range l.filePolicies {
+		named[name] = true
+	}
+	for _, settings := range []map[string]string{routes, ipLimits, tokenLimits, exempt, shadow} {
+		for name := range settings {
+			named[name] = true
+		}
+	}
+	
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	
+	var policies []PolicyConfig
+	seen := make(map[string]string)
+	
+	for _, name := range names {
+		policy := PolicyConfig{
+			Name:        name,
+			IPLimits:    defaultIPLimits,
+			TokenLimits: defaultTokenLimits,
+		}
+		
+		if _, exists := routes[name]; !exists {
+			if l.filePolicies[name] {
+				return nil, fmt.Errorf("invalid policy %s: missing policies.%s.route in %s", name, name, l.path)
+			}
+			return nil, fmt.Errorf("invalid policy %s: missing POLICY_%s_ROUTE", name, name)
+		}
+		
+		fields := strings.Fields(routes[name])
+		switch len(fields) {
+		case 1:
+			policy.Path = fields[0]
+		case 2:
+			policy.Method = strings.ToUpper(fields[0])
+			policy.Path = fields[1]
+		}
+		if !strings.HasPrefix(policy.Path, "/") {
+			return nil, fmt.Errorf("invalid %s %q: must be a path optionally preceded by a method", l.name("POLICY_"+name+"_ROUTE"), routes[name])
+		}
+		switch policy.Method {
+		case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+			http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		default:
+			return nil, fmt.Errorf("invalid %s %q: unknown method %s", l.name("POLICY_"+name+"_ROUTE"), routes[name], policy.Method)
+		}
+		
+		route := policy.Method + " " + policy.Path
+		if other, exists := seen[route]; exists {
+			return nil, fmt.Errorf("invalid %s %q: route already used by policy %s", l.name("POLICY_"+name+"_ROUTE"), routes[name], other)
+		}
+		seen[route] = name
 		
-		if strings.HasPrefix(key, "TOKEN_") && strings.HasSuffix(key, "_LIMIT") {
-			tokenName := strings.TrimSuffix(strings.TrimPrefix(key, "TOKEN_"), "_LIMIT")
-			if limit, err := strconv.Atoi(value); err == nil {
-				tokens[tokenName] = limit
+		var err error
+		if value, exists := ipLimits[name]; exists {
+			if policy.IPLimits, err = ratelimiter.ParseLimits(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", l.name("POLICY_"+name+"_IP_LIMIT"), err)
+			}
+		}
+		if value, exists := tokenLimits[name]; exists {
+			if policy.TokenLimits, err = ratelimiter.ParseLimits(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", l.name("POLICY_"+name+"_TOKEN_LIMIT"), err)
 			}
 		}
+		if value, exists := exempt[name]; exists {
+			if policy.Exempt, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", l.name("POLICY_"+name+"_EXEMPT"), value, err)
+			}
+		}
+		if value, exists := shadow[name]; exists {
+			if policy.Shadow, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", l.name("POLICY_"+name+"_SHADOW"), value, err)
+			}
+		}
+		
+		policies = append(policies, policy)
 	}
 	
-	return tokens
-} 
\ No newline at end of file
+	return policies, nil
+}
@@ -0,0 +1,258 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfigFile writes content to a config file and points CONFIG_FILE at it
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load()
+	assert.NoError(t, err)
+
+	assert.Equal(t, StorageRedis, cfg.Storage.Type)
+	assert.Equal(t, "6379", cfg.Redis.Port)
+	assert.Equal(t, ratelimiter.RedisStandalone, cfg.Redis.Mode)
+	assert.Equal(t, []string{"localhost:6379"}, cfg.Redis.Addrs)
+	assert.Nil(t, cfg.Redis.TLS)
+	assert.Equal(t, "8080", cfg.Server.Port)
+	assert.Equal(t, []ratelimiter.Limit{ratelimiter.PerSecond(10)}, cfg.RateLimit.DefaultIPLimits)
+	assert.Equal(t, 300*time.Second, cfg.RateLimit.BlockDuration)
+	assert.Equal(t, "/admin", cfg.Admin.Prefix)
+	assert.Equal(t, ratelimiter.FailClosed, cfg.Storage.FailurePolicy)
+	assert.Equal(t, 100*time.Millisecond, cfg.Storage.Timeout)
+	assert.Equal(t, "none", cfg.Tracing.Exporter)
+	assert.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+}
+
+func TestLoad_YAMLFile(t *testing.T) {
+	writeConfigFile(t, "config.yaml", `
+storage:
+  type: memory
+  failure_policy: local
+  timeout_ms: 50
+  near_cache: true
+redis:
+  db: 2
+  mode: cluster
+  addrs: [redis-0:6379, redis-1:6379]
+  username: limiter
+  tls:
+    enabled: true
+    server_name: redis.internal
+  pool_size: 50
+  read_timeout_ms: 200
+rate_limit:
+  algorithm: sliding_window
+  default_ip_limit: [10/s, 1000/d]
+  default_ip_burst: 20
+  block_duration_seconds: 60
+  block_escalation: [10s, 1m]
+server:
+  trusted_proxies: 10.0.0.0/8
+  shadow: true
+tokens:
+  abc123:
+    limit: 50/s
+  partner:
+    limit: 10/s,1000/h
+    burst: 15
+policies:
+  write:
+    route: POST /api/data
+    ip_limit: 2/s
+  health:
+    route: /health
+    exempt: true
+`)
+	t.Setenv("BLOCK_DURATION_SECONDS", "120")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+
+	assert.Equal(t, StorageMemory, cfg.Storage.Type)
+	assert.Equal(t, ratelimiter.FailLocal, cfg.Storage.FailurePolicy)
+	assert.Equal(t, 50*time.Millisecond, cfg.Storage.Timeout)
+	assert.True(t, cfg.Storage.NearCache)
+	assert.Equal(t, 2, cfg.Redis.DB)
+	assert.Equal(t, ratelimiter.RedisCluster, cfg.Redis.Mode)
+	assert.Equal(t, []string{"redis-0:6379", "redis-1:6379"}, cfg.Redis.Addrs)
+	assert.Equal(t, "limiter", cfg.Redis.Username)
+	if assert.NotNil(t, cfg.Redis.TLS) {
+		assert.Equal(t, "redis.internal", cfg.Redis.TLS.ServerName)
+	}
+	assert.Equal(t, 50, cfg.Redis.PoolSize)
+	assert.Equal(t, 200*time.Millisecond, cfg.Redis.ReadTimeout)
+	assert.Equal(t, ratelimiter.SlidingWindow, cfg.RateLimit.Algorithm)
+	assert.Equal(t, []ratelimiter.Limit{{Requests: 10, Window: time.Second, Burst: 20}, ratelimiter.PerDay(1000)}, cfg.RateLimit.DefaultIPLimits)
+	assert.Equal(t, []time.Duration{10 * time.Second, time.Minute}, cfg.RateLimit.BlockEscalation)
+	assert.True(t, cfg.Server.Shadow)
+	assert.Len(t, cfg.Server.TrustedProxies, 1)
+
+	// The environment overrides the file
+	assert.Equal(t, 120*time.Second, cfg.RateLimit.BlockDuration)
+
+	assert.Equal(t, map[string][]ratelimiter.Limit{
+		"abc123":  {ratelimiter.PerSecond(50)},
+		"partner": {{Requests: 10, Window: time.Second, Burst: 15}, ratelimiter.PerHour(1000)},
+	}, cfg.Tokens)
+
+	assert.Len(t, cfg.Policies, 2)
+	assert.Equal(t, "health", cfg.Policies[0].Name)
+	assert.True(t, cfg.Policies[0].Exempt)
+	assert.Equal(t, "write", cfg.Policies[1].Name)
+	assert.Equal(t, "POST", cfg.Policies[1].Method)
+	assert.Equal(t, []ratelimiter.Limit{ratelimiter.PerSecond(2)}, cfg.Policies[1].IPLimits)
+}
+
+func TestLoad_JSONFile(t *testing.T) {
+	writeConfigFile(t, "config.json", `{
+  "server": {"port": 9000, "standard_headers": true},
+  "tokens": {"abc123": {"limit": "5/m"}}
+}`)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "9000", cfg.Server.Port)
+	assert.True(t, cfg.Server.StandardHeaders)
+	assert.Equal(t, []ratelimiter.Limit{ratelimiter.PerMinute(5)}, cfg.Tokens["abc123"])
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		file     string
+		expected string
+	}{
+		{
+			name:     "unparsable number",
+			env:      map[string]string{"REDIS_DB": "one"},
+			expected: `invalid REDIS_DB "one"`,
+		},
+		{
+			name:     "negative burst",
+			env:      map[string]string{"DEFAULT_IP_BURST": "-5"},
+			expected: `invalid DEFAULT_IP_BURST "-5"`,
+		},
+		{
+			name:     "port out of range",
+			env:      map[string]string{"SERVER_PORT": "80800"},
+			expected: `invalid SERVER_PORT "80800"`,
+		},
+		{
+			name:     "unknown redis mode",
+			env:      map[string]string{"REDIS_MODE": "replica"},
+			expected: "invalid REDIS_MODE: unknown Redis mode",
+		},
+		{
+			name:     "sentinel without master",
+			env:      map[string]string{"REDIS_MODE": "sentinel"},
+			expected: "REDIS_MASTER_NAME is required with Redis Sentinel",
+		},
+		{
+			name:     "missing redis CA file",
+			env:      map[string]string{"REDIS_TLS": "true", "REDIS_TLS_CA_FILE": "/nonexistent/ca.pem"},
+			expected: "invalid REDIS_TLS_CA_FILE",
+		},
+		{
+			name:     "unknown failure policy",
+			env:      map[string]string{"FAILURE_POLICY": "ignore"},
+			expected: "invalid FAILURE_POLICY: unknown failure policy",
+		},
+		{
+			name:     "unknown trusted proxy header",
+			env:      map[string]string{"TRUSTED_PROXY_HEADER": "x-client-ip"},
+			expected: `invalid TRUSTED_PROXY_HEADER "x-client-ip"`,
+		},
+		{
+			name:     "unknown tracing exporter",
+			env:      map[string]string{"TRACING_EXPORTER": "jaeger"},
+			expected: `invalid TRACING_EXPORTER "jaeger"`,
+		},
+		{
+			name:     "sample ratio out of range",
+			file:     "tracing:\n  sample_ratio: 1.5\n",
+			expected: "invalid tracing.sample_ratio in ",
+		},
+		{
+			name:     "file value",
+			file:     "rate_limit:\n  default_ip_limit: ten/s\n",
+			expected: "invalid rate_limit.default_ip_limit in ",
+		},
+		{
+			name:     "file token value",
+			file:     "tokens:\n  abc123:\n    burst: lots\n",
+			expected: "invalid tokens.abc123.burst in ",
+		},
+		{
+			name:     "policy without route",
+			env:      map[string]string{"POLICY_write_IP_LIMIT": "2/s"},
+			expected: "invalid policy write: missing POLICY_write_ROUTE",
+		},
+		{
+			name:     "shadow policy without route",
+			env:      map[string]string{"POLICY_write_SHADOW": "true"},
+			expected: "invalid policy write: missing POLICY_write_ROUTE",
+		},
+		{
+			name:     "policy with unknown method",
+			env:      map[string]string{"POLICY_write_ROUTE": "POTS /api/data"},
+			expected: `invalid POLICY_write_ROUTE "POTS /api/data": unknown method POTS`,
+		},
+		{
+			name:     "file policy with unknown method",
+			file:     "policies:\n  write:\n    route: GETT /api/data\n",
+			expected: `config.yaml "GETT /api/data": unknown method GETT`,
+		},
+		{
+			name:     "file policy without route",
+			file:     "policies:\n  write:\n    ip_limit: 2/s\n",
+			expected: "invalid policy write: missing policies.write.route in ",
+		},
+		{
+			name:     "empty file policy",
+			file:     "policies:\n  write: {}\n",
+			expected: "invalid policy write: missing policies.write.route in ",
+		},
+		{
+			name:     "unknown file field",
+			file:     "server:\n  prot: 8080\n",
+			expected: "field prot not found",
+		},
+		{
+			name:     "nested file value",
+			file:     "server:\n  port:\n    number: 8080\n",
+			expected: "expected a value or a list of values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if tt.file != "" {
+				writeConfigFile(t, "config.yaml", tt.file)
+			}
+
+			_, err := Load()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the schema of the configuration file. Every setting has
+// the environment variable it stands for in its env tag, so file values go
+// through the same validation and environment variables override them.
+type fileConfig struct {
+	Storage   storageFile           `yaml:"storage"`
+	Redis     redisFile             `yaml:"redis"`
+	RateLimit rateLimitFile         `yaml:"rate_limit"`
+	Server    serverFile            `yaml:"server"`
+	Access    accessFile            `yaml:"access"`
+	Admin     adminFile             `yaml:"admin"`
+	Metrics   metricsFile           `yaml:"metrics"`
+	Tracing   tracingFile           `yaml:"tracing"`
+	Tokens    map[string]tokenFile  `yaml:"tokens"`
+	Policies  map[string]policyFile `yaml:"policies"`
+}
+
+type storageFile struct {
+	Type                         value `yaml:"type" env:"STORAGE_TYPE"`
+	MemoryCleanupIntervalSeconds value `yaml:"memory_cleanup_interval_seconds" env:"MEMORY_CLEANUP_INTERVAL_SECONDS"`
+	FailurePolicy                value `yaml:"failure_policy" env:"FAILURE_POLICY"`
+	TimeoutMs                    value `yaml:"timeout_ms" env:"STORAGE_TIMEOUT_MS"`
+	CircuitBreakerFailures       value `yaml:"circuit_breaker_failures" env:"CIRCUIT_BREAKER_FAILURES"`
+	CircuitBreakerOpenSeconds    value `yaml:"circuit_breaker_open_seconds" env:"CIRCUIT_BREAKER_OPEN_SECONDS"`
+	NearCache                    value `yaml:"near_cache" env:"NEAR_CACHE_ENABLED"`
+}
+
+type redisFile struct {
+	Host     value `yaml:"host" env:"REDIS_HOST"`
+	Port     value `yaml:"port" env:"REDIS_PORT"`
+	Password value `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       value `yaml:"db" env:"REDIS_DB"`
+
+	Mode             value        `yaml:"mode" env:"REDIS_MODE"`
+	Addrs            value        `yaml:"addrs" env:"REDIS_ADDRS"`
+	MasterName       value        `yaml:"master_name" env:"REDIS_MASTER_NAME"`
+	Username         value        `yaml:"username" env:"REDIS_USERNAME"`
+	SentinelUsername value        `yaml:"sentinel_username" env:"REDIS_SENTINEL_USERNAME"`
+	SentinelPassword value        `yaml:"sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+	TLS              redisTLSFile `yaml:"tls"`
+	PoolSize         value        `yaml:"pool_size" env:"REDIS_POOL_SIZE"`
+	DialTimeoutMs    value        `yaml:"dial_timeout_ms" env:"REDIS_DIAL_TIMEOUT_MS"`
+	ReadTimeoutMs    value        `yaml:"read_timeout_ms" env:"REDIS_READ_TIMEOUT_MS"`
+	WriteTimeoutMs   value        `yaml:"write_timeout_ms" env:"REDIS_WRITE_TIMEOUT_MS"`
+}
+
+type redisTLSFile struct {
+	Enabled            value `yaml:"enabled" env:"REDIS_TLS"`
+	CAFile             value `yaml:"ca_file" env:"REDIS_TLS_CA_FILE"`
+	ServerName         value `yaml:"server_name" env:"REDIS_TLS_SERVER_NAME"`
+	InsecureSkipVerify value `yaml:"insecure_skip_verify" env:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+}
+
+type rateLimitFile struct {
+	Algorithm            value `yaml:"algorithm" env:"RATE_LIMIT_ALGORITHM"`
+	IdentityMode         value `yaml:"identity_mode" env:"IDENTITY_MODE"`
+	UnknownTokenPolicy   value `yaml:"unknown_token_policy" env:"UNKNOWN_TOKEN_POLICY"`
+	IPv4PrefixLength     value `yaml:"ipv4_prefix_length" env:"IPV4_PREFIX_LENGTH"`
+	IPv6PrefixLength     value `yaml:"ipv6_prefix_length" env:"IPV6_PREFIX_LENGTH"`
+	DefaultIPLimit       value `yaml:"default_ip_limit" env:"DEFAULT_IP_LIMIT"`
+	DefaultTokenLimit    value `yaml:"default_token_limit" env:"DEFAULT_TOKEN_LIMIT"`
+	DefaultIPBurst       value `yaml:"default_ip_burst" env:"DEFAULT_IP_BURST"`
+	DefaultTokenBurst    value `yaml:"default_token_burst" env:"DEFAULT_TOKEN_BURST"`
+	BlockDurationSeconds value `yaml:"block_duration_seconds" env:"BLOCK_DURATION_SECONDS"`
+	BlockEscalation      value `yaml:"block_escalation" env:"BLOCK_ESCALATION"`
+	OffenceDecaySeconds  value `yaml:"offence_decay_seconds" env:"OFFENCE_DECAY_SECONDS"`
+}
+
+type serverFile struct {
+	Port                   value `yaml:"port" env:"SERVER_PORT"`
+	TrustedProxies         value `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	IgnoreForwardedHeaders value `yaml:"ignore_forwarded_headers" env:"IGNORE_FORWARDED_HEADERS"`
+	TrustedProxyHeader     value `yaml:"trusted_proxy_header" env:"TRUSTED_PROXY_HEADER"`
+	StandardHeaders        value `yaml:"standard_headers" env:"RATE_LIMIT_STANDARD_HEADERS"`
+	Shadow                 value `yaml:"shadow" env:"RATE_LIMIT_SHADOW"`
+}
+
+type accessFile struct {
+	Allowlist     value `yaml:"allowlist" env:"ALLOWLIST"`
+	Denylist      value `yaml:"denylist" env:"DENYLIST"`
+	File          value `yaml:"file" env:"ACCESS_LIST_FILE"`
+	ReloadSeconds value `yaml:"reload_seconds" env:"ACCESS_LIST_RELOAD_SECONDS"`
+}
+
+type adminFile struct {
+	Token  value `yaml:"token" env:"ADMIN_TOKEN"`
+	Port   value `yaml:"port" env:"ADMIN_PORT"`
+	Prefix value `yaml:"prefix" env:"ADMIN_PREFIX"`
+}
+
+type metricsFile struct {
+	Enabled                    value `yaml:"enabled" env:"METRICS_ENABLED"`
+	Port                       value `yaml:"port" env:"METRICS_PORT"`
+	Path                       value `yaml:"path" env:"METRICS_PATH"`
+	BlockedKeysIntervalSeconds value `yaml:"blocked_keys_interval_seconds" env:"METRICS_BLOCKED_KEYS_INTERVAL_SECONDS"`
+}
+
+type tracingFile struct {
+	Exporter     value `yaml:"exporter" env:"TRACING_EXPORTER"`
+	OTLPEndpoint value `yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure value `yaml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+	ServiceName  value `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	SampleRatio  value `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// tokenFile stands for the TOKEN_<name>_* variables of the token it is keyed by
+type tokenFile struct {
+	Limit value `yaml:"limit" env:"_LIMIT"`
+	Burst value `yaml:"burst" env:"_BURST"`
+}
+
+// policyFile stands for the POLICY_<name>_* variables of the policy it is keyed by
+type policyFile struct {
+	Route      value `yaml:"route" env:"_ROUTE"`
+	IPLimit    value `yaml:"ip_limit" env:"_IP_LIMIT"`
+	TokenLimit value `yaml:"token_limit" env:"_TOKEN_LIMIT"`
+	Exempt     value `yaml:"exempt" env:"_EXEMPT"`
+	Shadow     value `yaml:"shadow" env:"_SHADOW"`
+}
+
+// value is a setting of the configuration file. It accepts any scalar, or a
+// sequence of scalars that is joined with commas like the list variables.
+type value struct {
+	text string
+	set  bool
+}
+
+func (v *value) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil
+		}
+		v.text = node.Value
+	case yaml.SequenceNode:
+		items := make([]string, len(node.Content))
+		for i, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: list items must be scalars", item.Line)
+			}
+			items[i] = item.Value
+		}
+		v.text = strings.Join(items, ",")
+	default:
+		return fmt.Errorf("line %d: expected a value or a list of values", node.Line)
+	}
+
+	v.set = true
+	return nil
+}
+
+// setting is a value of the configuration file with the field it was read from
+type setting struct {
+	value string
+	field string
+}
+
+// loader reads settings from the environment, falling back to the
+// configuration file
+type loader struct {
+	path string
+	file map[string]setting
+
+	// filePolicies holds the names of the policies of the file, including
+	// the ones without any setting
+	filePolicies map[string]bool
+}
+
+// newLoader reads the configuration file at path, which may be YAML or
+// JSON. An empty path means no file.
+func newLoader(path string) (*loader, error) {
+	l := &loader{path: path, file: make(map[string]setting), filePolicies: make(map[string]bool)}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	l.flatten(reflect.ValueOf(file), "", "")
+	return l, nil
+}
+
+// flatten records the set values of the struct or map v under their
+// environment variable names
+func (l *loader) flatten(v reflect.Value, field, env string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		if leaf, ok := v.Interface().(value); ok {
+			if leaf.set {
+				l.file[env] = setting{value: leaf.text, field: field}
+			}
+			return
+		}
+
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			l.flatten(v.Field(i), join(field, name), env+f.Tag.Get("env"))
+		}
+	case reflect.Map:
+		prefix := map[string]string{"tokens": "TOKEN_", "policies": "POLICY_"}[field]
+		for _, key := range v.MapKeys() {
+			if field == "policies" {
+				l.filePolicies[key.String()] = true
+			}
+			l.flatten(v.MapIndex(key), join(field, key.String()), prefix+key.String())
+		}
+	}
+}
+
+// get returns the environment variable key, or the configuration file
+// value standing for it, or defaultValue when neither is set
+func (l *loader) get(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if setting, exists := l.file[key]; exists && setting.value != "" {
+		return setting.value
+	}
+	return defaultValue
+}
+
+// name describes where the value of key comes from, for error messages
+func (l *loader) name(key string) string {
+	if os.Getenv(key) == "" {
+		if setting, exists := l.file[key]; exists {
+			return fmt.Sprintf("%s in %s", setting.field, l.path)
+		}
+	}
+	return key
+}
+
+// getInt reads a whole number of at least min from key
+func (l *loader) getInt(key, defaultValue string, min int) (int, error) {
+	value := l.get(key, defaultValue)
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < min {
+		return 0, fmt.Errorf("invalid %s %q: must be a whole number of at least %d", l.name(key), value, min)
+	}
+
+	return n, nil
+}
+
+// getBool reads a boolean such as true, false, 1 or 0 from key
+func (l *loader) getBool(key, defaultValue string) (bool, error) {
+	value := l.get(key, defaultValue)
+
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", l.name(key), value)
+	}
+
+	return b, nil
+}
+
+// getRatio reads a fraction between 0 and 1 from key
+func (l *loader) getRatio(key, defaultValue string) (float64, error) {
+	value := l.get(key, defaultValue)
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid %s %q: must be a number between 0 and 1", l.name(key), value)
+	}
+
+	return f, nil
+}
+
+// getPort reads a TCP port from key. An empty default allows no port.
+func (l *loader) getPort(key, defaultValue string) (string, error) {
+	value := strings.TrimSpace(l.get(key, defaultValue))
+	if value == "" {
+		return "", nil
+	}
+
+	if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid %s %q: must be a port between 1 and 65535", l.name(key), value)
+	}
+
+	return value, nil
+}
+
+// prefixed collects the <prefix><name><suffix> variables and configuration
+// file values into a map keyed by name, the variables taking precedence
+func (l *loader) prefixed(prefix, suffix string) map[string]string {
+	values := make(map[string]string)
+
+	match := func(key, value string) {
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) {
+			name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+			values[name] = value
+		}
+	}
+
+	for key, setting := range l.file {
+		match(key, setting.value)
+	}
+
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		match(pair[0], pair[1])
+	}
+
+	return values
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
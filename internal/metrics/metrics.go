@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Decision outcomes
+const (
+	OutcomeAllowed      = "allowed"
+	OutcomeRejected     = "rejected"
+	OutcomeShadow       = "shadow_rejected"
+	OutcomeAllowlisted  = "allowlisted"
+	OutcomeDenylisted   = "denylisted"
+	OutcomeUnknownToken = "unknown_token"
+	OutcomeError        = "error"
+)
+
+// policyNamespace is the namespace prefix of the limiters of route policies
+const policyNamespace = "policy:"
+
+// Metrics records the decisions of rate limiters and the latency of their
+// storage as Prometheus metrics. It implements ratelimiter.Observer.
+type Metrics struct {
+	decisions        *prometheus.CounterVec
+	decisionDuration *prometheus.HistogramVec
+	storageDuration  *prometheus.HistogramVec
+}
+
+// New creates the metrics and registers them with registerer
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limiter_decisions_total",
+			Help: "Rate limit decisions by key type, policy and outcome.",
+		}, []string{"key_type", "policy", "outcome"}),
+		decisionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rate_limiter_decision_duration_seconds",
+			Help:    "Time taken to decide a request, including storage calls.",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"policy"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rate_limiter_storage_duration_seconds",
+			Help:    "Latency of storage calls by operation and status.",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "status"}),
+	}
+
+	registerer.MustRegister(m.decisions, m.decisionDuration, m.storageDuration)
+	return m
+}
+
+// ObserveDecision counts a decision and records how long it took
+func (m *Metrics) ObserveDecision(namespace string, result *ratelimiter.LimitResult, err error, elapsed time.Duration) {
+	policy := policyName(namespace)
+
+	keyType, outcome := "none", OutcomeError
+	switch {
+	case errors.Is(err, ratelimiter.ErrUnknownToken):
+		keyType, outcome = "token", OutcomeUnknownToken
+	case err != nil:
+	case result.Reason == ratelimiter.ReasonAllowlisted:
+		keyType, outcome = "ip", OutcomeAllowlisted
+	case result.Reason == ratelimiter.ReasonDenylisted:
+		keyType, outcome = "ip", OutcomeDenylisted
+	default:
+		_, keyType = splitKey(result.Key)
+		switch {
+		case result.Allowed:
+			outcome = OutcomeAllowed
+		case result.DryRun:
+			// Shadow mode let the request through
+			outcome = OutcomeShadow
+		default:
+			outcome = OutcomeRejected
+		}
+	}
+
+	m.decisions.WithLabelValues(keyType, policy, outcome).Inc()
+	m.decisionDuration.WithLabelValues(policy).Observe(elapsed.Seconds())
+}
+
+// observeStorage records the latency of a storage call
+func (m *Metrics) observeStorage(operation string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	m.storageDuration.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+}
+
+// DefaultBlockedKeysInterval is how often the blocked keys are listed when
+// no interval is given
+const DefaultBlockedKeysInterval = 30 * time.Second
+
+// blockedKeysTimeout bounds each listing of the blocked keys
+const blockedKeysTimeout = 5 * time.Second
+
+// BlockedKeys is a gauge of the keys currently blocked in storage by key
+// type and policy. The keys are listed in the background every interval
+// and scrapes report the last listing, so scrapes never reach the storage.
+type BlockedKeys struct {
+	storage ratelimiter.Storage
+	desc    *prometheus.Desc
+
+	mu     sync.Mutex
+	counts map[blockedKeysGroup]int
+	err    error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// blockedKeysGroup is a label set of the blocked keys gauge
+type blockedKeysGroup struct{ keyType, policy string }
+
+// RegisterBlockedKeys registers a BlockedKeys gauge of storage with
+// registerer, listing the keys every interval until Close is called
+func RegisterBlockedKeys(registerer prometheus.Registerer, storage ratelimiter.Storage, interval time.Duration) *BlockedKeys {
+	if interval <= 0 {
+		interval = DefaultBlockedKeysInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &BlockedKeys{
+		storage: storage,
+		desc: prometheus.NewDesc(
+			"rate_limiter_blocked_keys",
+			"Keys currently blocked by key type and policy.",
+			[]string{"key_type", "policy"}, nil,
+		),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	b.refresh(ctx)
+	registerer.MustRegister(b)
+	go b.run(ctx, interval)
+
+	return b
+}
+
+// run lists the blocked keys every interval until ctx is done
+func (b *BlockedKeys) run(ctx context.Context, interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.refresh(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh lists the blocked keys and counts them by label set. A failed
+// listing keeps the previous counts until the next one.
+func (b *BlockedKeys) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, blockedKeysTimeout)
+	defer cancel()
+
+	keys, err := b.storage.BlockedKeys(ctx)
+	if err != nil {
+		log.Printf("Failed to list blocked keys for metrics: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.err = err
+	if err != nil {
+		return
+	}
+
+	b.counts = make(map[blockedKeysGroup]int)
+	for _, key := range keys {
+		policy, keyType := splitKey(key.Key)
+		b.counts[blockedKeysGroup{keyType, policy}]++
+	}
+}
+
+func (b *BlockedKeys) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.desc
+}
+
+func (b *BlockedKeys) Collect(ch chan<- prometheus.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Nothing was ever listed
+	if b.counts == nil && b.err != nil {
+		ch <- prometheus.NewInvalidMetric(b.desc, b.err)
+		return
+	}
+
+	for g, count := range b.counts {
+		ch <- prometheus.MustNewConstMetric(b.desc, prometheus.GaugeValue, float64(count), g.keyType, g.policy)
+	}
+}
+
+// Close stops listing the blocked keys. It is safe to call more than once.
+func (b *BlockedKeys) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// policyName returns the policy of a limiter namespace, or "default" for
+// the limiter without a namespace
+func policyName(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return strings.TrimPrefix(namespace, policyNamespace)
+}
+
+// splitKey returns the policy and key type, "ip" or "token", of an identity
+// key such as "policy:login:ip:192.168.1.1"
+func splitKey(key string) (policy, keyType string) {
+	namespace := ""
+	if rest, found := strings.CutPrefix(key, policyNamespace); found {
+		name, _, _ := strings.Cut(rest, ":")
+		namespace = policyNamespace + name
+		key = strings.TrimPrefix(rest, name+":")
+	}
+
+	keyType, _, _ = strings.Cut(key, ":")
+	return policyName(namespace), keyType
+}
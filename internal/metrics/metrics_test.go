@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_Decisions(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := New(registry)
+
+	storage := ratelimiter.NewMemoryStorage(time.Minute)
+	defer storage.Close()
+
+	config := ratelimiter.Config{
+		DefaultIPLimits:    []ratelimiter.Limit{ratelimiter.PerMinute(1)},
+		DefaultTokenLimits: []ratelimiter.Limit{ratelimiter.PerMinute(5)},
+		BlockDuration:      time.Minute,
+		TokenLimits:        map[string][]ratelimiter.Limit{"abc123": {ratelimiter.PerMinute(5)}},
+		UnknownTokens:      ratelimiter.UnknownTokenReject,
+		AccessList:         ratelimiter.NewAccessList(nil, nil),
+		Observer:           m,
+	}
+	limiter := ratelimiter.New(InstrumentStorage(storage, m), config)
+
+	config.Namespace = "policy:write"
+	writeLimiter := ratelimiter.New(InstrumentStorage(storage, m), config)
+
+	ctx := context.Background()
+	limiter.CheckLimit(ctx, "192.168.1.1", "")
+	limiter.CheckLimit(ctx, "192.168.1.1", "")
+	limiter.CheckLimit(ctx, "192.168.1.1", "abc123")
+	limiter.CheckLimit(ctx, "192.168.1.1", "unknown")
+	writeLimiter.CheckLimit(ctx, "192.168.1.1", "")
+	writeLimiter.CheckLimit(ratelimiter.DryRun(ctx), "192.168.1.1", "")
+
+	expected := `
+# HELP rate_limiter_decisions_total Rate limit decisions by key type, policy and outcome.
+# TYPE rate_limiter_decisions_total counter
+rate_limiter_decisions_total{key_type="ip",outcome="allowed",policy="default"} 1
+rate_limiter_decisions_total{key_type="ip",outcome="allowed",policy="write"} 1
+rate_limiter_decisions_total{key_type="ip",outcome="rejected",policy="default"} 1
+rate_limiter_decisions_total{key_type="ip",outcome="shadow_rejected",policy="write"} 1
+rate_limiter_decisions_total{key_type="token",outcome="allowed",policy="default"} 1
+rate_limiter_decisions_total{key_type="token",outcome="unknown_token",policy="default"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(m.decisions, strings.NewReader(expected)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.decisionDuration))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.storageDuration), "every fixed window check is a single hit")
+
+	blockedKeys := RegisterBlockedKeys(registry, storage, time.Hour)
+	defer blockedKeys.Close()
+
+	expected = `
+# HELP rate_limiter_blocked_keys Keys currently blocked by key type and policy.
+# TYPE rate_limiter_blocked_keys gauge
+rate_limiter_blocked_keys{key_type="ip",policy="default"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "rate_limiter_blocked_keys"))
+
+	// Scrapes report the last listing until the next one
+	assert.NoError(t, storage.Unblock(ctx, "ip:192.168.1.1"))
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "rate_limiter_blocked_keys"))
+
+	blockedKeys.refresh(ctx)
+	assert.Equal(t, 0, testutil.CollectAndCount(blockedKeys))
+}
+
+func TestSplitKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		policy  string
+		keyType string
+	}{
+		{"ip:192.168.1.1", "default", "ip"},
+		{"token:abc123", "default", "token"},
+		{"ip:2001:db8::", "default", "ip"},
+		{"policy:write:ip:192.168.1.1", "write", "ip"},
+		{"policy:write:token:abc123", "write", "token"},
+	}
+
+	for _, tt := range tests {
+		policy, keyType := splitKey(tt.key)
+		assert.Equal(t, tt.policy, policy, tt.key)
+		assert.Equal(t, tt.keyType, keyType, tt.key)
+	}
+}
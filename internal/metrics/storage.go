@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+)
+
+// storage is a ratelimiter.Storage that records the latency of every call
+type storage struct {
+	next    ratelimiter.Storage
+	metrics *Metrics
+}
+
+// InstrumentStorage wraps next so that the latency of its calls is recorded in m
+func InstrumentStorage(next ratelimiter.Storage, m *Metrics) ratelimiter.Storage {
+	return &storage{next: next, metrics: m}
+}
+
+func (s *storage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	start := time.Now()
+	result, err := s.next.Increment(ctx, key, window)
+	s.metrics.observeStorage("increment", start, err)
+	return result, err
+}
+
+func (s *storage) Get(ctx context.Context, key string) (int64, error) {
+	start := time.Now()
+	result, err := s.next.Get(ctx, key)
+	s.metrics.observeStorage("get", start, err)
+	return result, err
+}
+
+func (s *storage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	start := time.Now()
+	err := s.next.SetBlock(ctx, key, duration)
+	s.metrics.observeStorage("set_block", start, err)
+	return err
+}
+
+func (s *storage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	result, err := s.next.IsBlocked(ctx, key)
+	s.metrics.observeStorage("is_blocked", start, err)
+	return result, err
+}
+
+func (s *storage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	start := time.Now()
+	result, err := s.next.BlockTTL(ctx, key)
+	s.metrics.observeStorage("block_ttl", start, err)
+	return result, err
+}
+
+func (s *storage) Hit(ctx context.Context, key string, counters []ratelimiter.Counter, blockDuration time.Duration) (*ratelimiter.HitResult, error) {
+	start := time.Now()
+	result, err := s.next.Hit(ctx, key, counters, blockDuration)
+	s.metrics.observeStorage("hit", start, err)
+	return result, err
+}
+
+func (s *storage) AddRequest(ctx context.Context, key string, logs []ratelimiter.Counter) ([]int64, error) {
+	start := time.Now()
+	result, err := s.next.AddRequest(ctx, key, logs)
+	s.metrics.observeStorage("add_request", start, err)
+	return result, err
+}
+
+func (s *storage) TakeToken(ctx context.Context, key string, buckets []ratelimiter.Bucket) ([]ratelimiter.TokenBucketState, error) {
+	start := time.Now()
+	result, err := s.next.TakeToken(ctx, key, buckets)
+	s.metrics.observeStorage("take_token", start, err)
+	return result, err
+}
+
+func (s *storage) ApplyGCRA(ctx context.Context, key string, buckets []ratelimiter.Bucket) ([]ratelimiter.GCRAState, error) {
+	start := time.Now()
+	result, err := s.next.ApplyGCRA(ctx, key, buckets)
+	s.metrics.observeStorage("apply_gcra", start, err)
+	return result, err
+}
+
+func (s *storage) Unblock(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.next.Unblock(ctx, key)
+	s.metrics.observeStorage("unblock", start, err)
+	return err
+}
+
+func (s *storage) Reset(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.next.Reset(ctx, key)
+	s.metrics.observeStorage("reset", start, err)
+	return err
+}
+
+func (s *storage) BlockedKeys(ctx context.Context) ([]ratelimiter.BlockedKey, error) {
+	start := time.Now()
+	result, err := s.next.BlockedKeys(ctx)
+	s.metrics.observeStorage("blocked_keys", start, err)
+	return result, err
+}
+
+func (s *storage) Close() error {
+	return s.next.Close()
+}
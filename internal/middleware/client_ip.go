@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Forwarding headers supported by IPResolver
+const (
+	HeaderXForwardedFor = "x-forwarded-for"
+	HeaderForwarded     = "forwarded"
+	HeaderXRealIP       = "x-real-ip"
+)
+
+// IPResolver extracts the client IP address of a request. Forwarding headers
+// are only honoured when the request comes from a trusted proxy, so clients
+// cannot spoof their address to dodge limits.
+type IPResolver struct {
+	// TrustedProxies are the proxies whose forwarding headers are believed
+	TrustedProxies []netip.Prefix
+
+	// Header is the only forwarding header read, the one the trusted proxies
+	// write: HeaderXForwardedFor (the default), HeaderForwarded or
+	// HeaderXRealIP. Proxies pass the other headers through from the client.
+	Header string
+
+	// IgnoreHeaders uses the connection address even for trusted proxies
+	IgnoreHeaders bool
+}
+
+// ClientIP returns the client IP address of r
+// The Forwarded (RFC 7239) and X-Forwarded-For chains are walked from right
+// to left, skipping trusted proxies, and the first untrusted hop is the
+// client. X-Real-IP holds the client as seen by the trusted proxy.
+func (r IPResolver) ClientIP(req *http.Request) string {
+	remote, err := parseHost(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	if r.IgnoreHeaders || !r.trusted(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	switch r.Header {
+	case HeaderForwarded:
+		hops = forwardedFor(req.Header.Values("Forwarded"))
+	case HeaderXRealIP:
+		if xri := req.Header.Get("X-Real-IP"); xri != "" {
+			if ip, err := netip.ParseAddr(strings.TrimSpace(xri)); err == nil {
+				return ip.Unmap().String()
+			}
+		}
+	default:
+		hops = xForwardedFor(req.Header.Values("X-Forwarded-For"))
+	}
+
+	if hops != nil {
+		return r.walk(remote, hops).String()
+	}
+
+	return remote.String()
+}
+
+// walk returns the first untrusted hop from the right of the chain. An
+// unparsable hop stops the walk, since nothing left of it can be trusted.
+func (r IPResolver) walk(remote netip.Addr, hops []string) netip.Addr {
+	client := remote
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := parseHost(hops[i])
+		if err != nil {
+			return client
+		}
+
+		client = ip
+		if !r.trusted(ip) {
+			return client
+		}
+	}
+
+	return client
+}
+
+// trusted reports whether ip belongs to a trusted proxy
+func (r IPResolver) trusted(ip netip.Addr) bool {
+	for _, prefix := range r.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// xForwardedFor splits the X-Forwarded-For headers into hops, left to right
+func xForwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor extracts the "for" parameters of the Forwarded headers, left to right
+// An element without one yields an empty hop, which stops the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHost parses an IP address with an optional port, such as
+// "192.0.2.1", "192.0.2.1:8080", "2001:db8::1" or "[2001:db8::1]:8080"
+// IPv4-mapped IPv6 addresses are returned as IPv4.
+func parseHost(value string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	ip, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return ip.Unmap(), nil
+}
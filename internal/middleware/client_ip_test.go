@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPResolver_ClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "192.168.1.1:12345",
+			expected:   "192.168.1.1",
+		},
+		{
+			name:       "headers from an untrusted client are ignored",
+			remoteAddr: "192.168.1.1:12345",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			expected:   "192.168.1.1",
+		},
+		{
+			name:       "multi-hop chain stops at the first untrusted hop",
+			remoteAddr: "10.0.0.2:12345",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.1"},
+			expected:   "1.2.3.4",
+		},
+		{
+			name:       "chain of trusted proxies only",
+			remoteAddr: "10.0.0.2:12345",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.1"},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "unparsable hop stops the walk",
+			remoteAddr: "10.0.0.2:12345",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, garbage, 10.0.0.1"},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "headers other than the configured one are ignored",
+			remoteAddr: "10.0.0.2:12345",
+			headers: map[string]string{
+				"Forwarded":       "for=6.6.6.6",
+				"X-Real-IP":       "6.6.6.6",
+				"X-Forwarded-For": "5.6.7.8",
+			},
+			expected: "5.6.7.8",
+		},
+		{
+			name:       "spoofed headers without the configured one",
+			remoteAddr: "10.0.0.2:12345",
+			headers: map[string]string{
+				"Forwarded": "for=6.6.6.6",
+				"X-Real-IP": "6.6.6.6",
+			},
+			expected: "10.0.0.2",
+		},
+		{
+			name:       "forwarded header",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:12345",
+			headers: map[string]string{
+				"Forwarded":       `for=1.2.3.4;proto=https, for="[2001:db8:ffff::1]:4711"`,
+				"X-Forwarded-For": "6.6.6.6",
+			},
+			expected: "1.2.3.4",
+		},
+		{
+			name:       "forwarded IPv6 client",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:12345",
+			headers:    map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`},
+			expected:   "2001:db8:cafe::17",
+		},
+		{
+			name:       "obfuscated forwarded hop stops the walk",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:12345",
+			headers:    map[string]string{"Forwarded": "for=1.2.3.4, for=_hidden"},
+			expected:   "10.0.0.2",
+		},
+		{
+			name:       "X-Real-IP from a trusted proxy",
+			header:     HeaderXRealIP,
+			remoteAddr: "10.0.0.2:12345",
+			headers: map[string]string{
+				"X-Real-IP":       "1.2.3.4",
+				"X-Forwarded-For": "6.6.6.6",
+			},
+			expected: "1.2.3.4",
+		},
+		{
+			name:       "IPv4-mapped remote address",
+			remoteAddr: "[::ffff:192.168.1.1]:12345",
+			expected:   "192.168.1.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			resolver := IPResolver{TrustedProxies: trustedProxies, Header: tt.header}
+			assert.Equal(t, tt.expected, resolver.ClientIP(req))
+		})
+	}
+}
+
+func TestIPResolver_IgnoreHeaders(t *testing.T) {
+	resolver := IPResolver{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		IgnoreHeaders:  true,
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:12345"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	req.Header.Set("X-Real-IP", "1.2.3.4")
+
+	assert.Equal(t, "10.0.0.2", resolver.ClientIP(req))
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/gin-gonic/gin"
+)
+
+// setRateLimitHeaders describes the limit state of result in the response
+// headers. The reset time is an absolute UTC timestamp, and the IETF
+// RateLimit-Policy and RateLimit fields are added when standard is set.
+func setRateLimitHeaders(c *gin.Context, result *ratelimiter.LimitResult, standard bool) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("X-RateLimit-Reset", result.ResetTime.UTC().Format(time.RFC3339))
+
+	if !standard {
+		return
+	}
+
+	// The window is unknown when the key was already blocked
+	if result.Rule.Window > 0 {
+		c.Header("RateLimit-Policy", fmt.Sprintf(`"default";q=%d;w=%d`, result.Limit, ceilSeconds(result.Rule.Window)))
+	}
+	c.Header("RateLimit", fmt.Sprintf(`"default";r=%d;t=%d`, result.Remaining, ceilSeconds(time.Until(result.ResetTime))))
+}
+
+// ceilSeconds rounds d up to whole seconds, never below zero
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/gin-gonic/gin"
+)
+
+// Logger logs every request like gin.Logger, with the API tokens of the
+// path and query redacted, such as those of the admin API in
+// /tokens/<token> and ?key=token:<token>
+func Logger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			redactPath(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactPath redacts the segment after a "tokens" segment of a path and
+// the token identity keys of its query
+func redactPath(path string) string {
+	path, rawQuery, hasQuery := strings.Cut(path, "?")
+
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "tokens" && segments[i] != "" {
+			segments[i] = ratelimiter.RedactToken(segments[i])
+		}
+	}
+	path = strings.Join(segments, "/")
+
+	if !hasQuery {
+		return path
+	}
+
+	// A query that cannot be parsed is left out, as it cannot be redacted
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return path
+	}
+	for _, values := range query {
+		for i, value := range values {
+			values[i] = ratelimiter.RedactKey(value)
+		}
+	}
+
+	return path + "?" + query.Encode()
+}
+
+// shadowLogInterval is the least time between two shadow mode log lines
+const shadowLogInterval = time.Second
+
+// throttledLog logs at most one line per interval, counting the lines it
+// drops so that a burst of requests cannot flood the log
+type throttledLog struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// Printf logs like log.Printf unless a line was logged within the interval
+func (l *throttledLog) Printf(format string, args ...any) {
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.last) < l.interval {
+		l.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		format += " (%d similar lines suppressed)"
+		args = append(args, suppressed)
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/api/data", "/api/data"},
+		{"/admin/tokens", "/admin/tokens"},
+		{"/admin/tokens/abc123", "/admin/tokens/sha256:6ca13d52"},
+		{"/admin/keys?key=ip:192.168.1.1", "/admin/keys?key=ip%3A192.168.1.1"},
+		{"/admin/keys?key=token:abc123", "/admin/keys?key=token%3Asha256%3A6ca13d52"},
+		{"/admin/blocks?key=policy:write:token:abc123", "/admin/blocks?key=policy%3Awrite%3Atoken%3Asha256%3A6ca13d52"},
+		{"/admin/keys?key=token:abc123;%zz", "/admin/keys"},
+	}
+
+	for _, tt := range tests {
+		redacted := redactPath(tt.path)
+		assert.Equal(t, tt.expected, redacted, tt.path)
+		assert.NotContains(t, redacted, "abc123", tt.path)
+	}
+}
+
+func TestThrottledLog(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	throttled := &throttledLog{interval: 50 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		throttled.Printf("line %d", i)
+	}
+	time.Sleep(60 * time.Millisecond)
+	throttled.Printf("line %d", 3)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "line 0")
+	assert.Contains(t, lines[1], "line 3 (2 similar lines suppressed)")
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures RateLimiterMiddleware
+type Option func(*options)
+
+type options struct {
+	policies        []Policy
+	ipResolver      IPResolver
+	standardHeaders bool
+	shadow          bool
+	failOpen        bool
+	tracerProvider  trace.TracerProvider
+	propagator      propagation.TextMapPropagator
+}
+
+// WithPolicies sets the per-route policies of the middleware
+// A policy for a specific method takes precedence over one for any method.
+func WithPolicies(policies ...Policy) Option {
+	return func(o *options) {
+		o.policies = append(o.policies, policies...)
+	}
+}
+
+// WithIPResolver sets how the client IP is extracted from requests
+// By default forwarding headers are ignored, as no proxy is trusted.
+func WithIPResolver(resolver IPResolver) Option {
+	return func(o *options) {
+		o.ipResolver = resolver
+	}
+}
+
+// WithStandardHeaders also emits the IETF RateLimit-Policy and RateLimit
+// header fields alongside the X-RateLimit-* headers
+func WithStandardHeaders() Option {
+	return func(o *options) {
+		o.standardHeaders = true
+	}
+}
+
+// WithShadowMode lets every request through, logging the ones the limits
+// would reject and marking them with an X-RateLimit-Shadow: exceeded header.
+// Requests are counted but keys are never blocked, see ratelimiter.DryRun.
+// Denylisted IPs and rejected tokens are still refused.
+func WithShadowMode() Option {
+	return func(o *options) {
+		o.shadow = true
+	}
+}
+
+// WithFailOpen lets requests through unlimited when the limiter fails, e.g.
+// while its storage is unavailable. By default such requests are refused.
+func WithFailOpen() Option {
+	return func(o *options) {
+		o.failOpen = true
+	}
+}
+
+// WithTracerProvider sets the provider of the middleware spans. By default
+// the global OpenTelemetry provider is used.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithPropagator sets how the trace context is extracted from incoming
+// requests. By default the W3C traceparent and tracestate headers are read.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagator = propagator
+	}
+}
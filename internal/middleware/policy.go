@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+)
+
+// Policy applies its own limiter to the requests matching Method and Path.
+// Path is a Gin route template such as "/api/:id" and is compared with the
+// route that matched the request, not the raw URL.
+type Policy struct {
+	// Method is the HTTP method to match; empty matches any method
+	Method string
+	Path   string
+
+	// Limiter replaces the default limiter for matching requests. It should
+	// use its own namespace so its counters are kept apart.
+	Limiter *ratelimiter.RateLimiter
+
+	// Exempt skips rate limiting entirely for matching requests
+	Exempt bool
+
+	// Shadow only logs the requests the policy would reject, as WithShadowMode.
+	// Requests are still counted but keys are never blocked, so a shadow
+	// policy without a Limiter counts them against the default limiter
+	// without blocking clients on the routes it enforces.
+	Shadow bool
+}
+
+// matchPolicy returns the policy for the given method and route template,
+// or nil if none matches
+func (o *options) matchPolicy(method, path string) *Policy {
+	var match *Policy
+
+	for i := range o.policies {
+		policy := &o.policies[i]
+		if policy.Path != path {
+			continue
+		}
+
+		if policy.Method == method {
+			return policy
+		}
+
+		if policy.Method == "" && match == nil {
+			match = policy
+		}
+	}
+
+	return match
+}
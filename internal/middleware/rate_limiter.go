@@ -1,25 +1,100 @@
 package middleware
 
 import (
-	"net"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName names the tracer of the middleware spans
+const tracerName = "github.com/danilotorchio/go-expert-rate-limiter/internal/middleware"
+
 // RateLimiterMiddleware creates a Gin middleware for rate limiting
-func RateLimiterMiddleware(limiter *ratelimiter.RateLimiter) gin.HandlerFunc {
+// Requests matching a policy use that policy's limiter instead of limiter.
+func RateLimiterMiddleware(limiter *ratelimiter.RateLimiter, opts ...Option) gin.HandlerFunc {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.tracerProvider == nil {
+		o.tracerProvider = otel.GetTracerProvider()
+	}
+	if o.propagator == nil {
+		o.propagator = propagation.TraceContext{}
+	}
+	tracer := o.tracerProvider.Tracer(tracerName)
+	shadowLog := &throttledLog{interval: shadowLogInterval}
+	
 	return func(c *gin.Context) {
+		limiter := limiter
+		shadow := o.shadow
+		if policy := o.matchPolicy(c.Request.Method, c.FullPath()); policy != nil {
+			if policy.Exempt {
+				c.Next()
+				return
+			}
+			if policy.Limiter != nil {
+				limiter = policy.Limiter
+			}
+			shadow = shadow || policy.Shadow
+		}
+		
 		// Extract IP address
-		ip := getClientIP(c)
+		ip := o.ipResolver.ClientIP(c.Request)
 		
 		// Extract API key token from header
 		token := c.GetHeader("API_KEY")
 		
-		// Check rate limit
-		result, err := limiter.CheckLimit(c.Request.Context(), ip, token)
+		// Continue the incoming trace, also in the handlers that follow
+		ctx := o.propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		c.Request = c.Request.WithContext(ctx)
+		
+		ctx, span := tracer.Start(ctx, "ratelimiter.middleware",
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", c.FullPath()),
+				attribute.Bool("ratelimiter.shadow", shadow),
+			),
+		)
+		
+		// Check rate limit. A dry run never blocks keys, which would
+		// reject the client on the routes that are enforced too.
+		if shadow {
+			ctx = ratelimiter.DryRun(ctx)
+		}
+		result, err := limiter.CheckLimit(ctx, ip, token)
+		if err != nil && !errors.Is(err, ratelimiter.ErrUnknownToken) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		
+		if errors.Is(err, ratelimiter.ErrUnknownToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid API key",
+			})
+			c.Abort()
+			return
+		}
+		if err != nil && o.failOpen {
+			c.Next()
+			return
+		}
+		if errors.Is(err, ratelimiter.ErrStorageUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "rate limiter unavailable",
+			})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Internal server error",
@@ -28,11 +103,33 @@ func RateLimiterMiddleware(limiter *ratelimiter.RateLimiter) gin.HandlerFunc {
 			return
 		}
 		
+		// Access list decisions carry no rate limit state
+		switch result.Reason {
+		case ratelimiter.ReasonAllowlisted:
+			c.Next()
+			return
+		case ratelimiter.ReasonDenylisted:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "access denied",
+			})
+			c.Abort()
+			return
+		}
+		
 		// Set rate limit headers
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
-		c.Header("X-RateLimit-Reset", result.ResetTime.Format("2006-01-02T15:04:05Z"))
+		setRateLimitHeaders(c, result, o.standardHeaders)
+		
+		if !result.Allowed && shadow {
+			// Dry run: record the would-be rejection and let the request through
+			shadowLog.Printf("Rate limit shadow: %s %s from %s would be rejected (key %s, limit %d, retry after %v)",
+				c.Request.Method, redactPath(c.Request.URL.Path), ip, ratelimiter.RedactKey(result.Key), result.Limit, result.RetryAfter)
+			c.Header("X-RateLimit-Shadow", "exceeded")
+			c.Next()
+			return
+		}
 		
 		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "you have reached the maximum number of requests or actions allowed within a certain time frame",
 			})
@@ -43,29 +140,3 @@ func RateLimiterMiddleware(limiter *ratelimiter.RateLimiter) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// getClientIP extracts the real client IP address
-func getClientIP(c *gin.Context) string {
-	// Check X-Forwarded-For header
-	if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the chain
-		if ip := net.ParseIP(xff); ip != nil {
-			return ip.String()
-		}
-	}
-	
-	// Check X-Real-IP header
-	if xri := c.GetHeader("X-Real-IP"); xri != "" {
-		if ip := net.ParseIP(xri); ip != nil {
-			return ip.String()
-		}
-	}
-	
-	// Fall back to remote address
-	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
-	if err != nil {
-		return c.Request.RemoteAddr
-	}
-	
-	return ip
-} 
\ No newline at end of file
@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the storage spans
+const tracerName = "github.com/danilotorchio/go-expert-rate-limiter/internal/tracing"
+
+// storage is a ratelimiter.Storage that creates a span for every call
+type storage struct {
+	next   ratelimiter.Storage
+	tracer trace.Tracer
+	system string
+}
+
+// InstrumentStorage wraps next so that each of its calls is a child span of
+// the span in its context. system names the backend, e.g. redis or memory.
+func InstrumentStorage(next ratelimiter.Storage, provider trace.TracerProvider, system string) ratelimiter.Storage {
+	return &storage{next: next, tracer: provider.Tracer(tracerName), system: system}
+}
+
+// start starts the span of a storage operation
+func (s *storage) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "ratelimiter.storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", s.system),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+}
+
+// end records err in span and ends it
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *storage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	ctx, span := s.start(ctx, "increment")
+	result, err := s.next.Increment(ctx, key, window)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) Get(ctx context.Context, key string) (int64, error) {
+	ctx, span := s.start(ctx, "get")
+	result, err := s.next.Get(ctx, key)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	ctx, span := s.start(ctx, "set_block")
+	err := s.next.SetBlock(ctx, key, duration)
+	end(span, err)
+	return err
+}
+
+func (s *storage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	ctx, span := s.start(ctx, "is_blocked")
+	result, err := s.next.IsBlocked(ctx, key)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, span := s.start(ctx, "block_ttl")
+	result, err := s.next.BlockTTL(ctx, key)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) Hit(ctx context.Context, key string, counters []ratelimiter.Counter, blockDuration time.Duration) (*ratelimiter.HitResult, error) {
+	ctx, span := s.start(ctx, "hit")
+	result, err := s.next.Hit(ctx, key, counters, blockDuration)
+	if err == nil {
+		span.SetAttributes(attribute.Bool("ratelimiter.blocked", result.Blocked))
+	}
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) AddRequest(ctx context.Context, key string, logs []ratelimiter.Counter) ([]int64, error) {
+	ctx, span := s.start(ctx, "add_request")
+	result, err := s.next.AddRequest(ctx, key, logs)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) TakeToken(ctx context.Context, key string, buckets []ratelimiter.Bucket) ([]ratelimiter.TokenBucketState, error) {
+	ctx, span := s.start(ctx, "take_token")
+	result, err := s.next.TakeToken(ctx, key, buckets)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) ApplyGCRA(ctx context.Context, key string, buckets []ratelimiter.Bucket) ([]ratelimiter.GCRAState, error) {
+	ctx, span := s.start(ctx, "apply_gcra")
+	result, err := s.next.ApplyGCRA(ctx, key, buckets)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) Unblock(ctx context.Context, key string) error {
+	ctx, span := s.start(ctx, "unblock")
+	err := s.next.Unblock(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (s *storage) Reset(ctx context.Context, key string) error {
+	ctx, span := s.start(ctx, "reset")
+	err := s.next.Reset(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (s *storage) BlockedKeys(ctx context.Context) ([]ratelimiter.BlockedKey, error) {
+	ctx, span := s.start(ctx, "blocked_keys")
+	result, err := s.next.BlockedKeys(ctx)
+	end(span, err)
+	return result, err
+}
+
+func (s *storage) Close() error {
+	return s.next.Close()
+}
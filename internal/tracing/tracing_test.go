@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttributes indexes the attributes of span by key
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestInstrumentStorage_CheckLimitSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	storage := ratelimiter.NewMemoryStorage(time.Minute)
+	defer storage.Close()
+
+	limiter := ratelimiter.New(InstrumentStorage(storage, provider, "memory"), ratelimiter.Config{
+		DefaultIPLimits:    []ratelimiter.Limit{ratelimiter.PerMinute(1)},
+		DefaultTokenLimits: []ratelimiter.Limit{ratelimiter.PerMinute(5)},
+		BlockDuration:      time.Minute,
+		TracerProvider:     provider,
+	})
+
+	ctx := context.Background()
+	limiter.CheckLimit(ctx, "192.168.1.1", "")
+	limiter.CheckLimit(ctx, "192.168.1.1", "")
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+
+	// Each storage call ends before the check it belongs to
+	hit, check := spans[0], spans[1]
+	assert.Equal(t, "ratelimiter.storage.hit", hit.Name())
+	assert.Equal(t, "ratelimiter.CheckLimit", check.Name())
+	assert.Equal(t, check.SpanContext().SpanID(), hit.Parent().SpanID())
+	assert.Equal(t, "memory", spanAttributes(hit)["db.system.name"].AsString())
+
+	attributes := spanAttributes(check)
+	assert.Equal(t, "ip", attributes["ratelimiter.key_type"].AsString())
+	assert.Equal(t, "allowed", attributes["ratelimiter.decision"].AsString())
+	assert.Equal(t, int64(1), attributes["ratelimiter.limit"].AsInt64())
+	assert.Equal(t, int64(0), attributes["ratelimiter.remaining"].AsInt64())
+	assert.False(t, attributes["ratelimiter.blocked"].AsBool())
+
+	attributes = spanAttributes(spans[3])
+	assert.Equal(t, "rejected", attributes["ratelimiter.decision"].AsString())
+	assert.True(t, attributes["ratelimiter.blocked"].AsBool())
+
+	// Neither the client IP nor the key is exported
+	for _, span := range spans {
+		for _, kv := range span.Attributes() {
+			assert.NotContains(t, kv.Value.Emit(), "192.168.1.1")
+		}
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, shutdown, err := NewProvider(context.Background(), Options{Exporter: ExporterNone})
+	assert.NoError(t, err)
+	assert.NotNil(t, provider)
+	assert.NoError(t, shutdown(context.Background()))
+
+	provider, shutdown, err = NewProvider(context.Background(), Options{Exporter: ExporterStdout, ServiceName: "test", SampleRatio: 1})
+	assert.NoError(t, err)
+	assert.IsType(t, &sdktrace.TracerProvider{}, provider)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, _, err = NewProvider(context.Background(), Options{Exporter: "jaeger"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,242 @@
+package ratelimiter
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reason explains a decision that was not made by the limits
+type Reason string
+
+const (
+	// ReasonAllowlisted means the client IP is allowlisted and bypasses the limits
+	ReasonAllowlisted Reason = "allowlisted"
+
+	// ReasonDenylisted means the client IP is denylisted and is always rejected
+	ReasonDenylisted Reason = "denylisted"
+)
+
+// AccessList holds allowlisted and denylisted IP addresses and CIDR ranges.
+// A denylist entry wins over an allowlist entry. It is safe for concurrent
+// use and can optionally be extended with entries from a file that is
+// reloaded when it changes.
+type AccessList struct {
+	mu        sync.RWMutex
+	allow     []netip.Prefix
+	deny      []netip.Prefix
+	baseAllow []netip.Prefix
+	baseDeny  []netip.Prefix
+
+	path      string
+	modTime   time.Time
+	size      int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAccessList creates an access list with the given entries
+func NewAccessList(allow, deny []netip.Prefix) *AccessList {
+	return &AccessList{
+		allow:     allow,
+		deny:      deny,
+		baseAllow: allow,
+		baseDeny:  deny,
+		done:      make(chan struct{}),
+	}
+}
+
+// Check returns the reason the access list decides ip, or an empty reason
+// when ip is in neither list
+func (a *AccessList) Check(ip string) Reason {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, prefix := range a.deny {
+		if prefix.Contains(addr) {
+			return ReasonDenylisted
+		}
+	}
+
+	for _, prefix := range a.allow {
+		if prefix.Contains(addr) {
+			return ReasonAllowlisted
+		}
+	}
+
+	return ""
+}
+
+// WatchFile adds the entries of the file at path to the list and, when
+// interval is positive, reloads them every time the file changes until
+// Close is called. A file that fails to reload keeps its previous entries.
+//
+// Each line of the file is "allow <ip or cidr>" or "deny <ip or cidr>";
+// blank lines and lines starting with # are ignored.
+func (a *AccessList) WatchFile(path string, interval time.Duration) error {
+	a.mu.Lock()
+	a.path = path
+	a.mu.Unlock()
+
+	if _, err := a.reload(); err != nil {
+		return err
+	}
+
+	if interval > 0 {
+		go a.watch(interval)
+	}
+
+	return nil
+}
+
+// watch periodically reloads the file until Close is called
+func (a *AccessList) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if reloaded, err := a.reload(); err != nil {
+				log.Printf("Failed to reload access list %s: %v", a.path, err)
+			} else if reloaded {
+				log.Printf("Reloaded access list %s", a.path)
+			}
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// reload rereads the file if its modification time or size changed and
+// reports whether it did
+func (a *AccessList) reload() (bool, error) {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read access list: %w", err)
+	}
+
+	a.mu.RLock()
+	unchanged := info.ModTime().Equal(a.modTime) && info.Size() == a.size
+	a.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	allow, deny, err := loadAccessListFile(a.path)
+	if err != nil {
+		return false, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.allow = append(append([]netip.Prefix(nil), a.baseAllow...), allow...)
+	a.deny = append(append([]netip.Prefix(nil), a.baseDeny...), deny...)
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+
+	return true, nil
+}
+
+// Close stops reloading the file. It is safe to call more than once.
+func (a *AccessList) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+
+	return nil
+}
+
+// loadAccessListFile parses the allow and deny entries of the file at path
+func loadAccessListFile(path string) (allow, deny []netip.Prefix, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read access list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("invalid access list %s line %d: expected \"allow|deny <ip or cidr>\"", path, line)
+		}
+
+		prefix, err := ParsePrefix(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid access list %s line %d: %w", path, line, err)
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			allow = append(allow, prefix)
+		case "deny":
+			deny = append(deny, prefix)
+		default:
+			return nil, nil, fmt.Errorf("invalid access list %s line %d: unknown action %q", path, line, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read access list: %w", err)
+	}
+
+	return allow, deny, nil
+}
+
+// ParsePrefix parses an IP address or CIDR range such as "10.0.0.0/8" or
+// "192.168.1.10". A single address becomes a range containing only itself.
+func ParsePrefix(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// ParsePrefixes parses a comma-separated list of IP addresses and CIDR ranges
+func ParsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, err := ParsePrefix(part)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
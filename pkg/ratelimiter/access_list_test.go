@@ -0,0 +1,76 @@
+package ratelimiter
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessList_Check(t *testing.T) {
+	list := NewAccessList(
+		[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		[]netip.Prefix{netip.MustParsePrefix("10.6.6.6/32")},
+	)
+
+	assert.Equal(t, ReasonAllowlisted, list.Check("10.1.2.3"))
+	assert.Equal(t, ReasonAllowlisted, list.Check("::ffff:10.1.2.3"))
+	assert.Equal(t, ReasonAllowlisted, list.Check("2001:db8::1"))
+	assert.Equal(t, ReasonDenylisted, list.Check("10.6.6.6"))
+	assert.Equal(t, Reason(""), list.Check("192.168.1.1"))
+	assert.Equal(t, Reason(""), list.Check("not-an-ip"))
+}
+
+func TestAccessList_WatchFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.list")
+	assert.NoError(t, os.WriteFile(path, []byte("# office\nallow 192.168.0.0/16\ndeny 1.2.3.4\n"), 0o644))
+
+	list := NewAccessList([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, nil)
+	t.Cleanup(func() { list.Close() })
+
+	assert.NoError(t, list.WatchFile(path, 0))
+	assert.Equal(t, ReasonAllowlisted, list.Check("192.168.1.1"))
+	assert.Equal(t, ReasonDenylisted, list.Check("1.2.3.4"))
+	assert.Equal(t, ReasonAllowlisted, list.Check("10.0.0.1"))
+
+	// A changed file replaces the previous file entries, keeping the base ones
+	assert.NoError(t, os.WriteFile(path, []byte("deny 5.6.7.8\n"), 0o644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	reloaded, err := list.reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, Reason(""), list.Check("192.168.1.1"))
+	assert.Equal(t, Reason(""), list.Check("1.2.3.4"))
+	assert.Equal(t, ReasonDenylisted, list.Check("5.6.7.8"))
+	assert.Equal(t, ReasonAllowlisted, list.Check("10.0.0.1"))
+
+	// An invalid file keeps the last good entries
+	assert.NoError(t, os.WriteFile(path, []byte("block 9.9.9.9\n"), 0o644))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+
+	_, err = list.reload()
+	assert.Error(t, err)
+	assert.Equal(t, ReasonDenylisted, list.Check("5.6.7.8"))
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes("10.0.0.0/8, 192.168.1.10,2001:db8::/32, 10.1.2.3/16")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.10/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+	}, prefixes)
+
+	prefixes, err = ParsePrefixes("")
+	assert.NoError(t, err)
+	assert.Empty(t, prefixes)
+
+	_, err = ParsePrefixes("10.0.0.0/8,garbage")
+	assert.Error(t, err)
+}
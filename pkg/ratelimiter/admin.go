@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// KeyState describes the stored state of an identity key such as
+// "ip:192.168.1.1" or "token:abc123"
+type KeyState struct {
+	Key      string
+	Blocked  bool
+	BlockTTL time.Duration
+
+	// Windows holds the current fixed window count of each limit of the key.
+	// It is empty for other algorithms, whose state is not a count, and for
+	// keys this limiter has no limits for.
+	Windows []WindowCount
+}
+
+// WindowCount is the request count of a limit in its current fixed window
+type WindowCount struct {
+	Limit     Limit
+	Count     int64
+	ResetTime time.Time
+}
+
+// Inspect returns the stored state of an identity key without counting a
+// request. Its storage calls are admin calls, which a CircuitBreakerStorage
+// never serves from its fallback.
+func (rl *RateLimiter) Inspect(ctx context.Context, key string) (*KeyState, error) {
+	ctx = adminContext(ctx)
+
+	blockTTL, err := rl.storage.BlockTTL(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block TTL: %w", err)
+	}
+
+	state := &KeyState{
+		Key:      key,
+		Blocked:  blockTTL != 0,
+		BlockTTL: blockTTL,
+	}
+
+	if rl.algorithm != "" && rl.algorithm != FixedWindow {
+		return state, nil
+	}
+
+	limits, err := rl.limitsOfKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := rl.now()
+	for _, limit := range limits {
+		count, err := rl.storage.Get(ctx, limit.counterKey(key, now))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get count: %w", err)
+		}
+
+		state.Windows = append(state.Windows, WindowCount{
+			Limit:     limit,
+			Count:     count,
+			ResetTime: limit.windowStart(now).Add(limit.Window),
+		})
+	}
+
+	return state, nil
+}
+
+// limitsOfKey returns the limits this limiter applies to an identity key,
+// or nil when the key is not one of its keys
+func (rl *RateLimiter) limitsOfKey(ctx context.Context, key string) ([]Limit, error) {
+	if rl.namespace != "" {
+		var found bool
+		if key, found = strings.CutPrefix(key, rl.namespace+":"); !found {
+			return nil, nil
+		}
+	}
+
+	if strings.HasPrefix(key, "ip:") {
+		return rl.defaultIPLimits, nil
+	}
+
+	token, found := strings.CutPrefix(key, "token:")
+	if !found {
+		return nil, nil
+	}
+
+	limits, known, err := rl.tokenRegistry.Lookup(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up token: %w", err)
+	}
+	if known && len(limits) > 0 {
+		return withDefaultWindow(limits), nil
+	}
+
+	return rl.defaultTokenLimits, nil
+}
+
+// Unblock lifts the block of an identity key, keeping its counters
+func (rl *RateLimiter) Unblock(ctx context.Context, key string) error {
+	return rl.storage.Unblock(ctx, key)
+}
+
+// Reset lifts the block of an identity key and clears all of its counters
+func (rl *RateLimiter) Reset(ctx context.Context, key string) error {
+	return rl.storage.Reset(ctx, key)
+}
+
+// BlockedKeys lists the identity keys that are currently blocked
+func (rl *RateLimiter) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	return rl.storage.BlockedKeys(ctx)
+}
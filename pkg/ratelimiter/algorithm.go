@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Algorithm selects how requests are counted against a limit
+type Algorithm string
+
+const (
+	// FixedWindow counts requests in consecutive windows. It is the default.
+	FixedWindow Algorithm = "fixed_window"
+
+	// SlidingWindow keeps a log of request timestamps and counts the ones
+	// within the last window, so bursts across a window boundary are rejected
+	SlidingWindow Algorithm = "sliding_window"
+
+	// TokenBucket refills a bucket at the limit rate up to a burst capacity
+	// and rejects requests while it is empty, without blocking the key
+	TokenBucket Algorithm = "token_bucket"
+
+	// GCRA spaces requests evenly at the limit rate with a burst tolerance,
+	// storing a single theoretical arrival time per key and never blocking it
+	GCRA Algorithm = "gcra"
+)
+
+// ErrUnknownAlgorithm is returned when an algorithm name is not recognised
+var ErrUnknownAlgorithm = errors.New("unknown rate limit algorithm")
+
+// ParseAlgorithm converts an algorithm name into an Algorithm.
+// An empty name selects FixedWindow.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch Algorithm(name) {
+	case "", FixedWindow:
+		return FixedWindow, nil
+	case SlidingWindow:
+		return SlidingWindow, nil
+	case TokenBucket:
+		return TokenBucket, nil
+	case GCRA:
+		return GCRA, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+	}
+}
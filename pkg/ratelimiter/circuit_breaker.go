@@ -0,0 +1,352 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// FailurePolicy selects how requests are handled when the storage fails
+type FailurePolicy string
+
+const (
+	// FailClosed rejects requests while the storage is unavailable. It is
+	// the default.
+	FailClosed FailurePolicy = "closed"
+
+	// FailOpen lets requests through unlimited while the storage is unavailable
+	FailOpen FailurePolicy = "open"
+
+	// FailLocal limits requests with an in-process storage while the shared
+	// storage is unavailable, so each instance enforces the limits on its own
+	FailLocal FailurePolicy = "local"
+)
+
+// Defaults of CircuitBreakerConfig
+const (
+	DefaultStorageTimeout   = 100 * time.Millisecond
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = 10 * time.Second
+	DefaultAdminTimeout     = 5 * time.Second
+)
+
+var (
+	// ErrStorageUnavailable is returned by CircuitBreakerStorage when a call
+	// fails or the circuit is open
+	ErrStorageUnavailable = errors.New("rate limit storage unavailable")
+
+	// ErrUnknownFailurePolicy is returned when a failure policy name is not recognised
+	ErrUnknownFailurePolicy = errors.New("unknown failure policy")
+)
+
+// ParseFailurePolicy converts a policy name into a FailurePolicy.
+// An empty name selects FailClosed.
+func ParseFailurePolicy(name string) (FailurePolicy, error) {
+	switch FailurePolicy(name) {
+	case "", FailClosed:
+		return FailClosed, nil
+	case FailOpen:
+		return FailOpen, nil
+	case FailLocal:
+		return FailLocal, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownFailurePolicy, name)
+	}
+}
+
+// CircuitBreakerConfig configures a CircuitBreakerStorage
+type CircuitBreakerConfig struct {
+	// Timeout bounds every storage call. Zero means DefaultStorageTimeout.
+	Timeout time.Duration
+
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Zero means DefaultFailureThreshold.
+	FailureThreshold int
+
+	// OpenDuration is how long the circuit stays open before a single call
+	// is let through to probe the storage. Zero means DefaultOpenDuration.
+	OpenDuration time.Duration
+
+	// AdminTimeout bounds the admin calls, which list or scan keys and may
+	// take longer than the rate limit calls. Zero means DefaultAdminTimeout.
+	AdminTimeout time.Duration
+
+	// Fallback serves the rate limit calls that fail or are made while the
+	// circuit is open, as with FailLocal. Nil means those calls return
+	// ErrStorageUnavailable.
+	Fallback Storage
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreakerStorage wraps a Storage with per-call timeouts and a circuit
+// breaker, so that an unavailable storage fails fast instead of slowing down
+// every request. Calls that fail are reported as ErrStorageUnavailable or,
+// when a fallback is set, served by the fallback instead.
+//
+// The admin calls Unblock, Reset and BlockedKeys, and the calls made by
+// RateLimiter.Inspect, bypass the circuit breaker: they always go to the
+// storage with their own timeout and never count as failures, so slow admin
+// calls cannot open the circuit for the requests. They never use the
+// fallback either, as its state is local to the instance.
+type CircuitBreakerStorage struct {
+	next             Storage
+	fallback         Storage
+	timeout          time.Duration
+	adminTimeout     time.Duration
+	failureThreshold int
+	openDuration     time.Duration
+
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	openUntil time.Time
+	now       func() time.Time
+}
+
+// NewCircuitBreakerStorage wraps next with a circuit breaker
+func NewCircuitBreakerStorage(next Storage, config CircuitBreakerConfig) *CircuitBreakerStorage {
+	b := &CircuitBreakerStorage{
+		next:             next,
+		fallback:         config.Fallback,
+		timeout:          config.Timeout,
+		adminTimeout:     config.AdminTimeout,
+		failureThreshold: config.FailureThreshold,
+		openDuration:     config.OpenDuration,
+		now:              time.Now,
+	}
+	if b.timeout <= 0 {
+		b.timeout = DefaultStorageTimeout
+	}
+	if b.adminTimeout <= 0 {
+		b.adminTimeout = DefaultAdminTimeout
+	}
+	if b.failureThreshold <= 0 {
+		b.failureThreshold = DefaultFailureThreshold
+	}
+	if b.openDuration <= 0 {
+		b.openDuration = DefaultOpenDuration
+	}
+
+	return b
+}
+
+// allow reports whether a call may go to the storage, moving an open
+// circuit whose time is up to half-open to let a single probe through
+func (b *CircuitBreakerStorage) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Before(b.openUntil) {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// A probe is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a call
+func (b *CircuitBreakerStorage) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.state != circuitClosed {
+			log.Printf("Rate limit storage recovered, closing circuit")
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.failures >= b.failureThreshold) {
+		log.Printf("Rate limit storage failing (%v), opening circuit for %v", err, b.openDuration)
+		b.state = circuitOpen
+		b.openUntil = b.now().Add(b.openDuration)
+	}
+}
+
+// abandon lets the next call probe the storage when a probe was cancelled
+func (b *CircuitBreakerStorage) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+		b.openUntil = b.now()
+	}
+}
+
+// adminCallKey marks the contexts of adminContext
+type adminCallKey struct{}
+
+// adminContext returns a context whose storage calls are admin calls, for
+// the admin reads made through the rate limit calls such as Get
+func adminContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminCallKey{}, true)
+}
+
+// call runs op against the storage with a timeout, falling back when the
+// circuit is open or the call fails. Calls under adminContext are admin calls.
+func call[T any](b *CircuitBreakerStorage, ctx context.Context, op func(context.Context, Storage) (T, error)) (T, error) {
+	var zero T
+
+	if ctx.Value(adminCallKey{}) != nil {
+		return adminCall(b, ctx, op)
+	}
+
+	if b.allow() {
+		callCtx, cancel := context.WithTimeout(ctx, b.timeout)
+		result, err := op(callCtx, b.next)
+		cancel()
+
+		// A request that was cancelled says nothing about the storage
+		if err != nil && ctx.Err() != nil {
+			b.abandon()
+			return zero, ctx.Err()
+		}
+
+		b.record(err)
+		if err == nil {
+			return result, nil
+		}
+
+		if b.fallback == nil {
+			return zero, fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+		}
+	} else if b.fallback == nil {
+		return zero, fmt.Errorf("%w: circuit open", ErrStorageUnavailable)
+	}
+
+	return op(ctx, b.fallback)
+}
+
+// adminCall runs op against the storage with the admin timeout, whatever
+// the state of the circuit and without affecting it
+func adminCall[T any](b *CircuitBreakerStorage, ctx context.Context, op func(context.Context, Storage) (T, error)) (T, error) {
+	var zero T
+
+	callCtx, cancel := context.WithTimeout(ctx, b.adminTimeout)
+	result, err := op(callCtx, b.next)
+	cancel()
+
+	if err != nil && ctx.Err() != nil {
+		return zero, ctx.Err()
+	}
+	if err != nil {
+		return zero, fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+	return result, nil
+}
+
+// Increment increments the request count for the given key
+func (b *CircuitBreakerStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) (int64, error) {
+		return s.Increment(ctx, key, window)
+	})
+}
+
+// Get retrieves the current count for the given key
+func (b *CircuitBreakerStorage) Get(ctx context.Context, key string) (int64, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) (int64, error) {
+		return s.Get(ctx, key)
+	})
+}
+
+// SetBlock sets a block for the given key with the specified duration
+func (b *CircuitBreakerStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	_, err := call(b, ctx, func(ctx context.Context, s Storage) (struct{}, error) {
+		return struct{}{}, s.SetBlock(ctx, key, duration)
+	})
+	return err
+}
+
+// IsBlocked checks if the given key is currently blocked
+func (b *CircuitBreakerStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) (bool, error) {
+		return s.IsBlocked(ctx, key)
+	})
+}
+
+// BlockTTL returns the remaining time of the block of the given key
+func (b *CircuitBreakerStorage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) (time.Duration, error) {
+		return s.BlockTTL(ctx, key)
+	})
+}
+
+// Hit checks the block, increments the counters and sets the block
+func (b *CircuitBreakerStorage) Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) (*HitResult, error) {
+		return s.Hit(ctx, key, counters, blockDuration)
+	})
+}
+
+// AddRequest records a request in the sliding window logs of the given key
+func (b *CircuitBreakerStorage) AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) ([]int64, error) {
+		return s.AddRequest(ctx, key, logs)
+	})
+}
+
+// TakeToken takes one token from the buckets of the given key
+func (b *CircuitBreakerStorage) TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) ([]TokenBucketState, error) {
+		return s.TakeToken(ctx, key, buckets)
+	})
+}
+
+// ApplyGCRA checks a request against the theoretical arrival times of the given key
+func (b *CircuitBreakerStorage) ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error) {
+	return call(b, ctx, func(ctx context.Context, s Storage) ([]GCRAState, error) {
+		return s.ApplyGCRA(ctx, key, buckets)
+	})
+}
+
+// Unblock removes the block of the given key
+func (b *CircuitBreakerStorage) Unblock(ctx context.Context, key string) error {
+	_, err := adminCall(b, ctx, func(ctx context.Context, s Storage) (struct{}, error) {
+		return struct{}{}, s.Unblock(ctx, key)
+	})
+	return err
+}
+
+// Reset removes the block and every counter of the given key
+func (b *CircuitBreakerStorage) Reset(ctx context.Context, key string) error {
+	_, err := adminCall(b, ctx, func(ctx context.Context, s Storage) (struct{}, error) {
+		return struct{}{}, s.Reset(ctx, key)
+	})
+	return err
+}
+
+// BlockedKeys lists the blocked keys with the remaining time of their blocks
+func (b *CircuitBreakerStorage) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	return adminCall(b, ctx, func(ctx context.Context, s Storage) ([]BlockedKey, error) {
+		return s.BlockedKeys(ctx)
+	})
+}
+
+// Close closes the storage and the fallback
+func (b *CircuitBreakerStorage) Close() error {
+	if b.fallback != nil {
+		b.fallback.Close()
+	}
+	return b.next.Close()
+}
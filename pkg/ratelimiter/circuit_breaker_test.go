@@ -0,0 +1,211 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var errRedisDown = errors.New("connection refused")
+
+// newTestBreaker wraps mockStorage with a breaker that opens after two
+// failures, with a clock the test controls
+func newTestBreaker(mockStorage *MockStorage, fallback Storage) (*CircuitBreakerStorage, *time.Time) {
+	now := testNow
+	breaker := NewCircuitBreakerStorage(mockStorage, CircuitBreakerConfig{
+		FailureThreshold: 2,
+		OpenDuration:     10 * time.Second,
+		Fallback:         fallback,
+	})
+	breaker.now = func() time.Time { return now }
+
+	return breaker, &now
+}
+
+func TestCircuitBreakerStorage_OpensAndRecovers(t *testing.T) {
+	mockStorage := new(MockStorage)
+	breaker, now := newTestBreaker(mockStorage, nil)
+	ctx := context.Background()
+
+	mockStorage.On("IsBlocked", mock.Anything, "ip:1").Return(false, errRedisDown).Twice()
+
+	// Failures are reported as unavailable until the threshold opens the circuit
+	for i := 0; i < 2; i++ {
+		_, err := breaker.IsBlocked(ctx, "ip:1")
+		assert.ErrorIs(t, err, ErrStorageUnavailable)
+		assert.ErrorIs(t, err, errRedisDown)
+	}
+
+	// An open circuit fails fast without calling the storage
+	_, err := breaker.IsBlocked(ctx, "ip:1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	mockStorage.AssertNumberOfCalls(t, "IsBlocked", 2)
+
+	// A failed probe opens it again
+	*now = now.Add(10 * time.Second)
+	mockStorage.On("IsBlocked", mock.Anything, "ip:1").Return(false, errRedisDown).Once()
+	_, err = breaker.IsBlocked(ctx, "ip:1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	_, err = breaker.IsBlocked(ctx, "ip:1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	mockStorage.AssertNumberOfCalls(t, "IsBlocked", 3)
+
+	// A successful probe closes it
+	*now = now.Add(10 * time.Second)
+	mockStorage.On("IsBlocked", mock.Anything, "ip:1").Return(true, nil)
+	blocked, err := breaker.IsBlocked(ctx, "ip:1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	blocked, err = breaker.IsBlocked(ctx, "ip:1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	mockStorage.AssertNumberOfCalls(t, "IsBlocked", 5)
+}
+
+func TestCircuitBreakerStorage_Timeout(t *testing.T) {
+	mockStorage := new(MockStorage)
+	breaker := NewCircuitBreakerStorage(mockStorage, CircuitBreakerConfig{Timeout: 10 * time.Millisecond})
+
+	mockStorage.On("Get", mock.Anything, "ip:1").
+		Run(func(args mock.Arguments) { <-args.Get(0).(context.Context).Done() }).
+		Return(int64(0), context.DeadlineExceeded)
+
+	start := time.Now()
+	_, err := breaker.Get(context.Background(), "ip:1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCircuitBreakerStorage_CancelledRequest(t *testing.T) {
+	mockStorage := new(MockStorage)
+	breaker, _ := newTestBreaker(mockStorage, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockStorage.On("Get", mock.Anything, "ip:1").Return(int64(0), context.Canceled)
+
+	// Requests given up by the client never open the circuit
+	for i := 0; i < 3; i++ {
+		_, err := breaker.Get(ctx, "ip:1")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, ErrStorageUnavailable)
+	}
+	mockStorage.AssertNumberOfCalls(t, "Get", 3)
+}
+
+func TestCircuitBreakerStorage_Fallback(t *testing.T) {
+	mockStorage := new(MockStorage)
+	fallback := NewMemoryStorage(time.Minute)
+	breaker, _ := newTestBreaker(mockStorage, fallback)
+	ctx := context.Background()
+
+	counters := []Counter{{Key: "ip:1:1m:0", Window: time.Minute, Limit: 2}}
+	mockStorage.On("Hit", mock.Anything, "ip:1", counters, time.Minute).Return(nil, errRedisDown)
+
+	// Failed calls and calls made while the circuit is open use the fallback
+	for i, expected := range []int64{1, 2, 3} {
+		result, err := breaker.Hit(ctx, "ip:1", counters, time.Minute)
+		assert.NoError(t, err, "hit %d", i)
+		assert.Equal(t, []int64{expected}, result.Counts)
+	}
+	mockStorage.AssertNumberOfCalls(t, "Hit", 2)
+
+	blocked, err := fallback.IsBlocked(ctx, "ip:1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	// Admin calls never act on the local state, even while the circuit is open
+	mockStorage.On("Unblock", mock.Anything, "ip:1").Return(errRedisDown).Once()
+	assert.ErrorIs(t, breaker.Unblock(ctx, "ip:1"), ErrStorageUnavailable)
+	blocked, err = fallback.IsBlocked(ctx, "ip:1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	mockStorage.On("Close").Return(nil)
+	assert.NoError(t, breaker.Close())
+}
+
+func TestCircuitBreakerStorage_AdminCallsBypassCircuit(t *testing.T) {
+	mockStorage := new(MockStorage)
+	breaker, _ := newTestBreaker(mockStorage, nil)
+	breaker.timeout = time.Millisecond
+	ctx := context.Background()
+
+	// Slow admin calls get their own timeout and never open the circuit
+	mockStorage.On("BlockedKeys", mock.Anything).
+		Run(func(args mock.Arguments) {
+			deadline, _ := args.Get(0).(context.Context).Deadline()
+			assert.WithinDuration(t, time.Now().Add(DefaultAdminTimeout), deadline, time.Second)
+		}).
+		Return([]BlockedKey{{Key: "ip:1", TTL: time.Minute}}, nil).Once()
+	mockStorage.On("BlockedKeys", mock.Anything).Return(nil, errRedisDown).Twice()
+
+	keys, err := breaker.BlockedKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []BlockedKey{{Key: "ip:1", TTL: time.Minute}}, keys)
+	for i := 0; i < 2; i++ {
+		_, err = breaker.BlockedKeys(ctx)
+		assert.ErrorIs(t, err, ErrStorageUnavailable)
+		assert.ErrorIs(t, err, errRedisDown)
+	}
+
+	mockStorage.On("IsBlocked", mock.Anything, "ip:1").Return(false, nil)
+	_, err = breaker.IsBlocked(ctx, "ip:1")
+	assert.NoError(t, err)
+
+	// An open circuit does not stop them either
+	mockStorage.On("IsBlocked", mock.Anything, "ip:2").Return(false, errRedisDown).Twice()
+	for i := 0; i < 2; i++ {
+		_, err = breaker.IsBlocked(ctx, "ip:2")
+		assert.ErrorIs(t, err, ErrStorageUnavailable)
+	}
+	mockStorage.On("Reset", mock.Anything, "ip:2").Return(nil)
+	assert.NoError(t, breaker.Reset(ctx, "ip:2"))
+
+	_, err = breaker.IsBlocked(ctx, "ip:1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	mockStorage.AssertNumberOfCalls(t, "IsBlocked", 3)
+}
+
+func TestCircuitBreakerStorage_InspectBypassesFallback(t *testing.T) {
+	mockStorage := new(MockStorage)
+	fallback := NewMemoryStorage(time.Minute)
+	breaker, _ := newTestBreaker(mockStorage, fallback)
+	rl := New(breaker, Config{DefaultIPLimits: []Limit{PerMinute(1)}, BlockDuration: time.Minute})
+	ctx := context.Background()
+
+	// Trip the breaker, so that the requests are limited by the fallback
+	mockStorage.On("Hit", mock.Anything, "ip:192.168.1.1", mock.Anything, time.Minute).Return(nil, errRedisDown)
+	for i := 0; i < 3; i++ {
+		_, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+		assert.NoError(t, err)
+	}
+	blocked, err := fallback.IsBlocked(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	// Inspecting reaches the failing storage instead of reporting the fallback state
+	mockStorage.On("BlockTTL", mock.Anything, "ip:192.168.1.1").Return(time.Duration(0), errRedisDown)
+	_, err = rl.Inspect(ctx, "ip:192.168.1.1")
+	assert.ErrorIs(t, err, ErrStorageUnavailable)
+	mockStorage.AssertNumberOfCalls(t, "BlockTTL", 1)
+
+	mockStorage.On("Close").Return(nil)
+	assert.NoError(t, breaker.Close())
+}
+
+func TestParseFailurePolicy(t *testing.T) {
+	for name, expected := range map[string]FailurePolicy{"": FailClosed, "closed": FailClosed, "open": FailOpen, "local": FailLocal} {
+		policy, err := ParseFailurePolicy(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, policy)
+	}
+
+	_, err := ParseFailurePolicy("ignore")
+	assert.ErrorIs(t, err, ErrUnknownFailurePolicy)
+}
@@ -0,0 +1,67 @@
+package ratelimiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IdentityMode selects which identities of a request are rate limited
+type IdentityMode string
+
+const (
+	// IdentityToken limits requests with a token by the token alone and
+	// requests without one by IP. It is the default.
+	IdentityToken IdentityMode = "token"
+
+	// IdentityIP limits every request by IP and ignores tokens
+	IdentityIP IdentityMode = "ip"
+
+	// IdentityIPAndToken limits requests with a token by both the IP and the
+	// token, so a request must satisfy the limits of each
+	IdentityIPAndToken IdentityMode = "ip_and_token"
+)
+
+// ErrUnknownIdentityMode is returned when an identity mode name is not recognised
+var ErrUnknownIdentityMode = errors.New("unknown identity mode")
+
+// ParseIdentityMode converts an identity mode name into an IdentityMode.
+// An empty name selects IdentityToken.
+func ParseIdentityMode(name string) (IdentityMode, error) {
+	switch IdentityMode(name) {
+	case "", IdentityToken:
+		return IdentityToken, nil
+	case IdentityIP:
+		return IdentityIP, nil
+	case IdentityIPAndToken:
+		return IdentityIPAndToken, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownIdentityMode, name)
+	}
+}
+
+// RedactToken returns a short hash of an API token for the logs, so that
+// the requests of a token can be told apart without revealing it
+func RedactToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// RedactKey redacts the token of a token identity key, which may be namespaced
+func RedactKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		j := strings.Index(key[i:], "token:")
+		if j < 0 {
+			break
+		}
+		i += j
+
+		// Only at the start of a segment, not within a namespace such as "policy:mytoken"
+		if i == 0 || key[i-1] == ':' {
+			return key[:i] + "token:" + RedactToken(key[i+len("token:"):])
+		}
+	}
+	return key
+}
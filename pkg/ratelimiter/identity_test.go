@@ -0,0 +1,18 @@
+package ratelimiter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "sha256:6ca13d52", RedactToken("abc123"))
+	assert.NotContains(t, RedactToken("abc123"), "abc123")
+
+	assert.Equal(t, "token:sha256:6ca13d52", RedactKey("token:abc123"))
+	assert.Equal(t, "policy:write:token:sha256:6ca13d52", RedactKey("policy:write:token:abc123"))
+	assert.Equal(t, "ip:192.168.1.1", RedactKey("ip:192.168.1.1"))
+	assert.Equal(t, "policy:mytoken:ip:192.168.1.1", RedactKey("policy:mytoken:ip:192.168.1.1"))
+	assert.Equal(t, "policy:mytoken:token:sha256:6ca13d52", RedactKey("policy:mytoken:token:abc123"))
+}
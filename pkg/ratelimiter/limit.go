@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit represents a quota of Requests per Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+
+	// Burst is the token bucket capacity or GCRA burst size
+	// A zero value means a burst equal to Requests
+	Burst int
+}
+
+// PerSecond returns a limit of n requests per second
+func PerSecond(n int) Limit {
+	return Limit{Requests: n, Window: time.Second}
+}
+
+// PerMinute returns a limit of n requests per minute
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Window: time.Minute}
+}
+
+// PerHour returns a limit of n requests per hour
+func PerHour(n int) Limit {
+	return Limit{Requests: n, Window: time.Hour}
+}
+
+// PerDay returns a limit of n requests per day
+func PerDay(n int) Limit {
+	return Limit{Requests: n, Window: 24 * time.Hour}
+}
+
+// windowUnits maps the unit suffixes accepted by ParseLimit to their window
+var windowUnits = map[string]time.Duration{
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// ParseLimit parses a limit such as "10/s", "50/m", "1000/h", "5000/d" or
+// "100/30s". A bare number such as "10" means requests per second.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+
+	requestsPart, windowPart, hasWindow := strings.Cut(value, "/")
+
+	requests, err := strconv.Atoi(strings.TrimSpace(requestsPart))
+	if err != nil || requests < 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: requests must be a non-negative integer", value)
+	}
+
+	if !hasWindow {
+		return PerSecond(requests), nil
+	}
+
+	windowPart = strings.ToLower(strings.TrimSpace(windowPart))
+
+	window, ok := windowUnits[windowPart]
+	if !ok {
+		window, err = time.ParseDuration(windowPart)
+		if err != nil || window <= 0 {
+			return Limit{}, fmt.Errorf("invalid limit %q: window must be s, m, h, d or a positive duration", value)
+		}
+	}
+
+	return Limit{Requests: requests, Window: window}, nil
+}
+
+// ParseLimits parses a comma-separated list of limits such as "10/s,1000/d"
+// Each window may appear only once.
+func ParseLimits(value string) ([]Limit, error) {
+	var limits []Limit
+	windows := make(map[time.Duration]bool)
+
+	for _, part := range strings.Split(value, ",") {
+		limit, err := ParseLimit(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if windows[limit.Window] {
+			return nil, fmt.Errorf("invalid limits %q: more than one limit with a %s window", value, limit.Window)
+		}
+		windows[limit.Window] = true
+
+		limits = append(limits, limit)
+	}
+
+	return limits, nil
+}
+
+// String formats the limit in the form accepted by ParseLimit
+func (l Limit) String() string {
+	switch l.Window {
+	case time.Second:
+		return fmt.Sprintf("%d/s", l.Requests)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", l.Requests)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", l.Requests)
+	case 24 * time.Hour:
+		return fmt.Sprintf("%d/d", l.Requests)
+	default:
+		return fmt.Sprintf("%d/%s", l.Requests, l.Window)
+	}
+}
+
+// key returns the storage key of this limit for the given identity key.
+// Limits of the same identity are told apart by their window.
+func (l Limit) key(key string) string {
+	return fmt.Sprintf("%s:%s", key, l.Window)
+}
+
+// counterKey returns the storage key of the fixed window counter of this
+// limit containing now
+func (l Limit) counterKey(key string, now time.Time) string {
+	return fmt.Sprintf("%s:%d", l.key(key), l.windowStart(now).UnixMilli())
+}
+
+// burst returns the burst size, defaulting to the number of requests
+func (l Limit) burst() int {
+	if l.Burst <= 0 {
+		return l.Requests
+	}
+	return l.Burst
+}
+
+// interval returns the time a single request is worth, which is how often
+// a token bucket refills and how GCRA spaces requests
+func (l Limit) interval() time.Duration {
+	return l.Window / time.Duration(l.Requests)
+}
+
+// windowStart returns the start of the fixed window containing now.
+// Windows are aligned to multiples of their length, so hourly windows start
+// on the hour and daily windows at midnight UTC.
+func (l Limit) windowStart(now time.Time) time.Time {
+	return now.Truncate(l.Window)
+}
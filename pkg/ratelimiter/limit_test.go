@@ -0,0 +1,66 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Limit
+	}{
+		{"10", PerSecond(10)},
+		{"10/s", PerSecond(10)},
+		{"50/m", PerMinute(50)},
+		{"50/minute", PerMinute(50)},
+		{"1000/h", PerHour(1000)},
+		{"5000/d", PerDay(5000)},
+		{" 100 / 30s ", Limit{Requests: 100, Window: 30 * time.Second}},
+		{"0/s", PerSecond(0)},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.value)
+		assert.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+}
+
+func TestParseLimit_Invalid(t *testing.T) {
+	for _, value := range []string{"", "abc", "-1/s", "10/week", "10/-5s", "10/0s", "10/"} {
+		_, err := ParseLimit(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("10/s, 500/m,10000/d")
+	assert.NoError(t, err)
+	assert.Equal(t, []Limit{PerSecond(10), PerMinute(500), PerDay(10000)}, limits)
+
+	_, err = ParseLimits("10/s,20")
+	assert.Error(t, err)
+
+	_, err = ParseLimits("10/s,")
+	assert.Error(t, err)
+}
+
+func TestLimit_StringRoundTrip(t *testing.T) {
+	for _, limit := range []Limit{PerSecond(10), PerMinute(50), PerHour(1000), PerDay(5000), {Requests: 100, Window: 90 * time.Second}} {
+		parsed, err := ParseLimit(limit.String())
+		assert.NoError(t, err)
+		assert.Equal(t, limit, parsed)
+	}
+}
+
+func TestLimit_WindowStart(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 34, 56, 789, time.UTC)
+
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 34, 56, 0, time.UTC), PerSecond(1).windowStart(now))
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 34, 0, 0, time.UTC), PerMinute(1).windowStart(now))
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), PerHour(1).windowStart(now))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), PerDay(1).windowStart(now))
+}
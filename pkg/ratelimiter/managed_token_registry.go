@@ -0,0 +1,275 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TokenStore persists the tokens managed at runtime and notifies every
+// replica sharing it of their changes
+type TokenStore interface {
+	// Tokens returns every stored token with its limits
+	Tokens(ctx context.Context) (map[string][]Limit, error)
+
+	// Token returns the limits of token and whether it is stored
+	Token(ctx context.Context, token string) ([]Limit, bool, error)
+
+	// SetToken creates or updates token and notifies the watchers
+	SetToken(ctx context.Context, token string, limits []Limit) error
+
+	// DeleteToken deletes token, notifies the watchers and reports whether it existed
+	DeleteToken(ctx context.Context, token string) (bool, error)
+
+	// WatchTokens calls changed with every token set or deleted through the
+	// store, or with an empty token when every token must be reloaded,
+	// until ctx is done
+	WatchTokens(ctx context.Context, changed func(token string)) error
+}
+
+// ErrStaticToken is returned when changing a token configured at startup
+var ErrStaticToken = errors.New("token is configured statically")
+
+// ManagedToken is a token known to a ManagedTokenRegistry
+type ManagedToken struct {
+	Token  string
+	Limits []Limit
+
+	// Static tokens were configured at startup and cannot be changed at runtime
+	Static bool
+}
+
+// ManagedTokenRegistry is a TokenRegistry whose tokens can be created,
+// updated and revoked at runtime. Tokens are cached in memory, persisted in
+// a TokenStore and kept in sync with the other replicas sharing it.
+// It is safe for concurrent use.
+type ManagedTokenRegistry struct {
+	static map[string][]Limit
+	store  TokenStore
+
+	mu      sync.RWMutex
+	managed map[string][]Limit
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManagedTokenRegistry creates a registry of the static tokens plus the
+// tokens of store, watching store for changes until Close is called.
+// A nil store keeps the managed tokens in memory only.
+func NewManagedTokenRegistry(ctx context.Context, store TokenStore, static map[string][]Limit) (*ManagedTokenRegistry, error) {
+	r := &ManagedTokenRegistry{
+		static:  static,
+		store:   store,
+		managed: make(map[string][]Limit),
+		cancel:  func() {},
+		done:    make(chan struct{}),
+	}
+
+	if store == nil {
+		close(r.done)
+		return r, nil
+	}
+
+	if err := r.reload(ctx, ""); err != nil {
+		return nil, err
+	}
+
+	watchCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		defer close(r.done)
+
+		err := store.WatchTokens(watchCtx, func(token string) {
+			if err := r.reload(watchCtx, token); err != nil && watchCtx.Err() == nil {
+				log.Printf("Failed to reload token registry: %v", err)
+			}
+		})
+		if err != nil && watchCtx.Err() == nil {
+			log.Printf("Stopped watching token registry: %v", err)
+		}
+	}()
+
+	return r, nil
+}
+
+// Lookup returns the limits of token and whether it is known
+func (r *ManagedTokenRegistry) Lookup(ctx context.Context, token string) ([]Limit, bool, error) {
+	if limits, exists := r.static[token]; exists {
+		return limits, true, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	limits, exists := r.managed[token]
+	return limits, exists, nil
+}
+
+// Tokens returns every known token sorted by name
+func (r *ManagedTokenRegistry) Tokens() []ManagedToken {
+	tokens := make([]ManagedToken, 0, len(r.static))
+	for token, limits := range r.static {
+		tokens = append(tokens, ManagedToken{Token: token, Limits: limits, Static: true})
+	}
+
+	r.mu.RLock()
+	for token, limits := range r.managed {
+		if _, exists := r.static[token]; !exists {
+			tokens = append(tokens, ManagedToken{Token: token, Limits: limits})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Token < tokens[j].Token
+	})
+
+	return tokens
+}
+
+// SetToken creates or updates a managed token. Nil limits give it the
+// default token limits.
+func (r *ManagedTokenRegistry) SetToken(ctx context.Context, token string, limits []Limit) error {
+	if _, exists := r.static[token]; exists {
+		return fmt.Errorf("%w: %q", ErrStaticToken, token)
+	}
+
+	if r.store != nil {
+		if err := r.store.SetToken(ctx, token, limits); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.managed[token] = limits
+	r.mu.Unlock()
+
+	return nil
+}
+
+// RevokeToken deletes a managed token and reports whether it existed.
+// The token is then limited by the unknown token policy.
+func (r *ManagedTokenRegistry) RevokeToken(ctx context.Context, token string) (bool, error) {
+	if _, exists := r.static[token]; exists {
+		return false, fmt.Errorf("%w: %q", ErrStaticToken, token)
+	}
+
+	var existed bool
+	if r.store != nil {
+		var err error
+		if existed, err = r.store.DeleteToken(ctx, token); err != nil {
+			return false, err
+		}
+	}
+
+	r.mu.Lock()
+	_, cached := r.managed[token]
+	delete(r.managed, token)
+	r.mu.Unlock()
+
+	return existed || cached, nil
+}
+
+// reload rereads token from the store, or every token when it is empty
+func (r *ManagedTokenRegistry) reload(ctx context.Context, token string) error {
+	if token == "" {
+		tokens, err := r.store.Tokens(ctx)
+		if err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		r.managed = tokens
+		r.mu.Unlock()
+
+		return nil
+	}
+
+	limits, exists, err := r.store.Token(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if exists {
+		r.managed[token] = limits
+	} else {
+		delete(r.managed, token)
+	}
+
+	return nil
+}
+
+// Close stops watching the store for changes
+func (r *ManagedTokenRegistry) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// tokenRecord is the stored form of the limits of a token, in the format of
+// the TOKEN_<name>_LIMIT and TOKEN_<name>_BURST variables
+type tokenRecord struct {
+	Limits string `json:"limits"`
+	Burst  int    `json:"burst,omitempty"`
+}
+
+// encodeTokenLimits encodes limits as a tokenRecord. Only the burst of the
+// first limit is kept.
+func encodeTokenLimits(limits []Limit) (string, error) {
+	var record tokenRecord
+
+	parts := make([]string, len(limits))
+	for i, limit := range limits {
+		parts[i] = limit.String()
+	}
+	record.Limits = strings.Join(parts, ",")
+	if len(limits) > 0 {
+		record.Burst = limits[0].Burst
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// decodeTokenLimits decodes limits encoded by encodeTokenLimits
+func decodeTokenLimits(value string) ([]Limit, error) {
+	var record tokenRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, fmt.Errorf("invalid token record: %w", err)
+	}
+
+	return ParseTokenLimits(record.Limits, record.Burst)
+}
+
+// ParseTokenLimits parses the limits of a token such as "10/s,1000/d" with
+// a burst for the first limit. Empty limits mean the default token limits
+// and are returned as nil.
+func ParseTokenLimits(value string, burst int) ([]Limit, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	limits, err := ParseLimits(value)
+	if err != nil {
+		return nil, err
+	}
+	if burst < 0 {
+		return nil, fmt.Errorf("invalid burst %d: must not be negative", burst)
+	}
+	limits[0].Burst = burst
+
+	return limits, nil
+}
@@ -0,0 +1,223 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTokenStore is a TokenStore shared by registries in the same process,
+// standing in for the replicas of a deployment
+type fakeTokenStore struct {
+	mu       sync.Mutex
+	tokens   map[string][]Limit
+	watchers []chan string
+}
+
+func newFakeTokenStore() *fakeTokenStore {
+	return &fakeTokenStore{tokens: make(map[string][]Limit)}
+}
+
+func (s *fakeTokenStore) Tokens(ctx context.Context) (map[string][]Limit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make(map[string][]Limit, len(s.tokens))
+	for token, limits := range s.tokens {
+		tokens[token] = limits
+	}
+	return tokens, nil
+}
+
+func (s *fakeTokenStore) Token(ctx context.Context, token string) ([]Limit, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limits, exists := s.tokens[token]
+	return limits, exists, nil
+}
+
+func (s *fakeTokenStore) SetToken(ctx context.Context, token string, limits []Limit) error {
+	s.mu.Lock()
+	s.tokens[token] = limits
+	s.mu.Unlock()
+
+	s.publish(token)
+	return nil
+}
+
+func (s *fakeTokenStore) DeleteToken(ctx context.Context, token string) (bool, error) {
+	s.mu.Lock()
+	_, exists := s.tokens[token]
+	delete(s.tokens, token)
+	s.mu.Unlock()
+
+	s.publish(token)
+	return exists, nil
+}
+
+func (s *fakeTokenStore) WatchTokens(ctx context.Context, changed func(token string)) error {
+	ch := make(chan string, 16)
+	s.mu.Lock()
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+
+	for {
+		select {
+		case token := <-ch:
+			changed(token)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *fakeTokenStore) publish(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ch := range s.watchers {
+		ch <- token
+	}
+}
+
+func TestManagedTokenRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, err := NewManagedTokenRegistry(ctx, nil, map[string][]Limit{"abc123": {PerSecond(50)}})
+	assert.NoError(t, err)
+	defer registry.Close()
+
+	assert.NoError(t, registry.SetToken(ctx, "partner", []Limit{PerSecond(10), PerHour(1000)}))
+	assert.NoError(t, registry.SetToken(ctx, "basic", nil))
+
+	limits, known, err := registry.Lookup(ctx, "partner")
+	assert.NoError(t, err)
+	assert.True(t, known)
+	assert.Equal(t, []Limit{PerSecond(10), PerHour(1000)}, limits)
+
+	limits, known, _ = registry.Lookup(ctx, "basic")
+	assert.True(t, known)
+	assert.Nil(t, limits)
+
+	assert.Equal(t, []ManagedToken{
+		{Token: "abc123", Limits: []Limit{PerSecond(50)}, Static: true},
+		{Token: "basic"},
+		{Token: "partner", Limits: []Limit{PerSecond(10), PerHour(1000)}},
+	}, registry.Tokens())
+
+	// Static tokens cannot be changed at runtime
+	assert.ErrorIs(t, registry.SetToken(ctx, "abc123", nil), ErrStaticToken)
+	_, err = registry.RevokeToken(ctx, "abc123")
+	assert.ErrorIs(t, err, ErrStaticToken)
+
+	revoked, err := registry.RevokeToken(ctx, "partner")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, known, _ = registry.Lookup(ctx, "partner")
+	assert.False(t, known)
+
+	revoked, err = registry.RevokeToken(ctx, "partner")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestManagedTokenRegistry_Propagation(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeTokenStore()
+	assert.NoError(t, store.SetToken(ctx, "existing", []Limit{PerMinute(5)}))
+
+	replica1, err := NewManagedTokenRegistry(ctx, store, nil)
+	assert.NoError(t, err)
+	defer replica1.Close()
+
+	replica2, err := NewManagedTokenRegistry(ctx, store, nil)
+	assert.NoError(t, err)
+	defer replica2.Close()
+
+	_, known, _ := replica2.Lookup(ctx, "existing")
+	assert.True(t, known)
+
+	// Wait for both watchers to subscribe before changing tokens
+	assert.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.watchers) == 2
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, replica1.SetToken(ctx, "new", []Limit{PerSecond(3)}))
+	assert.Eventually(t, func() bool {
+		limits, known, _ := replica2.Lookup(ctx, "new")
+		return known && limits[0] == PerSecond(3)
+	}, time.Second, time.Millisecond)
+
+	_, err = replica1.RevokeToken(ctx, "existing")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, known, _ := replica2.Lookup(ctx, "existing")
+		return !known
+	}, time.Second, time.Millisecond)
+}
+
+func TestRateLimiter_CheckLimit_ManagedTokens(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	registry, err := NewManagedTokenRegistry(ctx, nil, nil)
+	assert.NoError(t, err)
+	defer registry.Close()
+
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		TokenRegistry:      registry,
+		UnknownTokens:      UnknownTokenReject,
+	}
+	rl := New(storage, config)
+	rl.now = clock.Now
+
+	_, err = rl.CheckLimit(ctx, "192.168.1.1", "partner")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+
+	assert.NoError(t, registry.SetToken(ctx, "partner", []Limit{PerSecond(1)}))
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "partner")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Limit)
+
+	// Policies recognise the token but apply their own token limits
+	config.TokenRegistry = KnownTokens{registry}
+	config.Namespace = "policy:write"
+	policy := New(storage, config)
+	policy.now = clock.Now
+
+	result, err = policy.CheckLimit(ctx, "192.168.1.1", "partner")
+	assert.NoError(t, err)
+	assert.Equal(t, 100, result.Limit)
+
+	_, err = registry.RevokeToken(ctx, "partner")
+	assert.NoError(t, err)
+	_, err = rl.CheckLimit(ctx, "192.168.1.1", "partner")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+}
+
+func TestTokenLimitsEncoding(t *testing.T) {
+	limits := []Limit{{Requests: 10, Window: time.Second, Burst: 20}, PerDay(1000)}
+
+	encoded, err := encodeTokenLimits(limits)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"limits":"10/s,1000/d","burst":20}`, encoded)
+
+	decoded, err := decodeTokenLimits(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, limits, decoded)
+
+	encoded, err = encodeTokenLimits(nil)
+	assert.NoError(t, err)
+	decoded, err = decodeTokenLimits(encoded)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded)
+}
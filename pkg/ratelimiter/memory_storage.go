@@ -0,0 +1,473 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCleanupInterval is how often MemoryStorage evicts expired keys when
+// no interval is given
+const DefaultCleanupInterval = time.Minute
+
+// MemoryStorage implements Storage interface using process memory.
+// It is safe for concurrent use but is not shared between instances, so it
+// is meant for single-instance deployments and local development.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+	blocks   map[string]time.Time
+	logs     map[string]*memoryLog
+	buckets  map[string]memoryBucket
+	tats     map[string]time.Time
+
+	now       func() time.Time
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// memoryLog holds the request timestamps of a sliding window, oldest first
+type memoryLog struct {
+	timestamps []time.Time
+	window     time.Duration
+}
+
+// prune drops timestamps that fell out of the window ending at now
+func (l *memoryLog) prune(now time.Time) {
+	cutoff := now.Add(-l.window)
+
+	i := 0
+	for i < len(l.timestamps) && !l.timestamps[i].After(cutoff) {
+		i++
+	}
+
+	l.timestamps = l.timestamps[i:]
+}
+
+// memoryBucket holds the fractional token count of a token bucket
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryStorage creates a new in-memory storage instance that evicts
+// expired keys every cleanupInterval
+func NewMemoryStorage(cleanupInterval time.Duration) *MemoryStorage {
+	if cleanupInterval <= 0 {
+		cleanupInterval = DefaultCleanupInterval
+	}
+
+	m := &MemoryStorage{
+		counters: make(map[string]memoryCounter),
+		blocks:   make(map[string]time.Time),
+		logs:     make(map[string]*memoryLog),
+		buckets:  make(map[string]memoryBucket),
+		tats:     make(map[string]time.Time),
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+
+	go m.cleanup(cleanupInterval)
+
+	return m
+}
+
+// Increment increments the request count for the given key.
+// The counter expires window after the first increment.
+func (m *MemoryStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.increment(key, window, m.now()), nil
+}
+
+// increment increments the counter of key, starting a new one that expires
+// window after now if it does not exist or has expired. The caller must hold mu.
+func (m *MemoryStorage) increment(key string, window time.Duration, now time.Time) int64 {
+	counter, exists := m.counters[key]
+	if !exists || !now.Before(counter.expiresAt) {
+		counter = memoryCounter{expiresAt: now.Add(window)}
+	}
+
+	counter.count++
+	m.counters[key] = counter
+
+	return counter.count
+}
+
+// Get retrieves the current count for the given key
+func (m *MemoryStorage) Get(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.count(key, m.now()), nil
+}
+
+// count returns the count of the counter of key at now, or zero if it does
+// not exist or has expired. The caller must hold mu.
+func (m *MemoryStorage) count(key string, now time.Time) int64 {
+	counter, exists := m.counters[key]
+	if !exists || !now.Before(counter.expiresAt) {
+		return 0
+	}
+
+	return counter.count
+}
+
+// SetBlock sets a block for the given key with the specified duration
+func (m *MemoryStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks[key] = m.now().Add(duration)
+
+	return nil
+}
+
+// IsBlocked checks if the given key is currently blocked
+func (m *MemoryStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blockedUntil, exists := m.blocks[key]
+	if !exists {
+		return false, nil
+	}
+
+	if !m.now().Before(blockedUntil) {
+		delete(m.blocks, key)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// BlockTTL returns how long the given key remains blocked
+func (m *MemoryStorage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blockedUntil, exists := m.blocks[key]
+	if !exists {
+		return 0, nil
+	}
+
+	now := m.now()
+	if !now.Before(blockedUntil) {
+		delete(m.blocks, key)
+		return 0, nil
+	}
+
+	return blockedUntil.Sub(now), nil
+}
+
+// Hit atomically checks the block and the counters, then either increments
+// the counters or sets the block
+func (m *MemoryStorage) Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	if blockedUntil, exists := m.blocks[key]; exists && now.Before(blockedUntil) {
+		return &HitResult{Blocked: true, BlockTTL: blockedUntil.Sub(now)}, nil
+	}
+
+	result := &HitResult{Counts: make([]int64, len(counters))}
+	exceeded := false
+
+	for i, counter := range counters {
+		result.Counts[i] = m.count(counter.Key, now) + 1
+		if result.Counts[i] > counter.Limit {
+			exceeded = true
+		}
+	}
+
+	if !exceeded {
+		for _, counter := range counters {
+			m.increment(counter.Key, counter.Window, now)
+		}
+	}
+
+	if exceeded && blockDuration > 0 {
+		m.blocks[key] = now.Add(blockDuration)
+		result.Blocked = true
+		result.BlockTTL = blockDuration
+	}
+
+	return result, nil
+}
+
+// AddRequest records a request in the sliding window log of every limit
+// when each of them has room for it
+func (m *MemoryStorage) AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	counts := make([]int64, len(logs))
+	allowed := true
+
+	for i, log := range logs {
+		if windowLog, exists := m.logs[log.Key]; exists {
+			windowLog.window = log.Window
+			windowLog.prune(now)
+			counts[i] = int64(len(windowLog.timestamps))
+		}
+
+		counts[i]++
+		if counts[i] > log.Limit {
+			allowed = false
+		}
+	}
+
+	if !allowed {
+		return counts, nil
+	}
+
+	for _, log := range logs {
+		windowLog, exists := m.logs[log.Key]
+		if !exists {
+			windowLog = &memoryLog{window: log.Window}
+			m.logs[log.Key] = windowLog
+		}
+		windowLog.timestamps = append(windowLog.timestamps, now)
+	}
+
+	return counts, nil
+}
+
+// TakeToken takes one token from the bucket of every limit when each of
+// them holds one
+func (m *MemoryStorage) TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	refilled := make([]memoryBucket, len(buckets))
+	allowed := true
+
+	for i, b := range buckets {
+		limit := float64(b.Burst)
+
+		bucket, exists := m.buckets[b.Key]
+		if !exists || !now.Before(bucket.expiresAt) {
+			bucket = memoryBucket{tokens: limit, updatedAt: now}
+		}
+
+		elapsed := now.Sub(bucket.updatedAt)
+		bucket.tokens = math.Min(limit, bucket.tokens+float64(elapsed)/float64(b.Interval))
+		bucket.updatedAt = now
+
+		refilled[i] = bucket
+		if bucket.tokens < 1 {
+			allowed = false
+		}
+	}
+
+	states := make([]TokenBucketState, len(buckets))
+	for i, b := range buckets {
+		limit := float64(b.Burst)
+		bucket := refilled[i]
+
+		states[i].Allowed = bucket.tokens >= 1
+		if allowed {
+			bucket.tokens--
+		}
+
+		states[i].Tokens = int64(bucket.tokens)
+		if bucket.tokens < limit {
+			_, frac := math.Modf(bucket.tokens)
+			states[i].NextToken = time.Duration(math.Ceil((1 - frac) * float64(b.Interval)))
+		}
+
+		// The bucket is indistinguishable from a new one once it refills completely
+		bucket.expiresAt = now.Add(time.Duration(math.Ceil((limit - bucket.tokens) * float64(b.Interval))))
+		m.buckets[b.Key] = bucket
+	}
+
+	return states, nil
+}
+
+// ApplyGCRA checks a request against the theoretical arrival time of every
+// limit and updates them when the request conforms to all of them
+func (m *MemoryStorage) ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	states := make([]GCRAState, len(buckets))
+	newTATs := make([]time.Time, len(buckets))
+	allowed := true
+
+	for i, b := range buckets {
+		tat, exists := m.tats[b.Key]
+		if !exists || tat.Before(now) {
+			tat = now
+		}
+
+		newTATs[i] = tat.Add(b.Interval)
+		allowAt := newTATs[i].Add(-b.Interval * time.Duration(b.Burst))
+		diff := now.Sub(allowAt)
+
+		remaining := int64(math.Floor(float64(diff) / float64(b.Interval)))
+		if remaining < 0 {
+			states[i] = GCRAState{
+				Allowed:    false,
+				Remaining:  0,
+				RetryAfter: -diff,
+				ResetAfter: tat.Sub(now),
+			}
+			allowed = false
+			continue
+		}
+
+		states[i] = GCRAState{
+			Allowed:    true,
+			Remaining:  remaining,
+			ResetAfter: newTATs[i].Sub(now),
+		}
+	}
+
+	if !allowed {
+		return states, nil
+	}
+
+	// The stored TAT doubles as the key's expiry: once it is in the past
+	// the key behaves exactly like a new one
+	for i, b := range buckets {
+		m.tats[b.Key] = newTATs[i]
+	}
+
+	return states, nil
+}
+
+// Unblock removes the block of the given key
+func (m *MemoryStorage) Unblock(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blocks, key)
+
+	return nil
+}
+
+// Reset removes the block and every entry of the given key and its limits
+func (m *MemoryStorage) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blocks, key)
+	deleteKeyEntries(m.counters, key, 2)
+	deleteKeyEntries(m.logs, key, 1)
+	deleteKeyEntries(m.buckets, key, 1)
+	deleteKeyEntries(m.tats, key, 1)
+
+	return nil
+}
+
+// deleteKeyEntries deletes key and the keys of its limits, which are key
+// followed by segments colon-separated segments, such as "<key>:<window>".
+// Matching the number of segments keeps the keys of identities key is a
+// prefix of, such as the IPv6 address "ip:2001:db8::1:5" of "ip:2001:db8::1".
+func deleteKeyEntries[V any](entries map[string]V, key string, segments int) {
+	for entryKey := range entries {
+		suffix, found := strings.CutPrefix(entryKey, key+":")
+		if entryKey == key || (found && strings.Count(suffix, ":") == segments-1) {
+			delete(entries, entryKey)
+		}
+	}
+}
+
+// BlockedKeys lists the keys whose block has not expired
+func (m *MemoryStorage) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	blocked := make([]BlockedKey, 0, len(m.blocks))
+	for key, blockedUntil := range m.blocks {
+		if now.Before(blockedUntil) {
+			blocked = append(blocked, BlockedKey{Key: key, TTL: blockedUntil.Sub(now)})
+		}
+	}
+
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].Key < blocked[j].Key
+	})
+
+	return blocked, nil
+}
+
+// Close stops the background eviction. It is safe to call more than once.
+func (m *MemoryStorage) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+
+	return nil
+}
+
+// cleanup periodically evicts expired counters and blocks until Close is called
+func (m *MemoryStorage) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evictExpired()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// evictExpired removes every entry whose TTL has elapsed
+func (m *MemoryStorage) evictExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	for key, counter := range m.counters {
+		if !now.Before(counter.expiresAt) {
+			delete(m.counters, key)
+		}
+	}
+
+	for key, blockedUntil := range m.blocks {
+		if !now.Before(blockedUntil) {
+			delete(m.blocks, key)
+		}
+	}
+
+	for key, bucket := range m.buckets {
+		if !now.Before(bucket.expiresAt) {
+			delete(m.buckets, key)
+		}
+	}
+
+	for key, tat := range m.tats {
+		if !now.Before(tat) {
+			delete(m.tats, key)
+		}
+	}
+
+	for key, windowLog := range m.logs {
+		windowLog.prune(now)
+		if len(windowLog.timestamps) == 0 {
+			delete(m.logs, key)
+		}
+	}
+}
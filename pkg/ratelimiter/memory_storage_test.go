@@ -0,0 +1,120 @@
+package ratelimiter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock for storage tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func newTestMemoryStorage(t *testing.T) (*MemoryStorage, *fakeClock) {
+	clock := newFakeClock()
+	storage := NewMemoryStorage(time.Hour)
+	storage.now = clock.Now
+	t.Cleanup(func() { storage.Close() })
+	return storage, clock
+}
+
+func TestMemoryStorage_EvictExpired(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	storage.Increment(ctx, "ip:192.168.1.1", time.Second)
+	storage.Increment(ctx, "ip:192.168.1.2", time.Minute)
+	storage.SetBlock(ctx, "ip:192.168.1.1", time.Second)
+	storage.AddRequest(ctx, "ip:192.168.1.1", []Counter{{Key: "ip:192.168.1.1:1s", Window: time.Second, Limit: 10}})
+	storage.TakeToken(ctx, "ip:192.168.1.1", []Bucket{{Key: "ip:192.168.1.1:1s", Burst: 10, Interval: 100 * time.Millisecond}})
+	storage.ApplyGCRA(ctx, "ip:192.168.1.1", []Bucket{{Key: "ip:192.168.1.1:1s", Burst: 10, Interval: 100 * time.Millisecond}})
+
+	clock.Advance(2 * time.Second)
+	storage.evictExpired()
+
+	assert.NotContains(t, storage.counters, "ip:192.168.1.1")
+	assert.Contains(t, storage.counters, "ip:192.168.1.2")
+	assert.Empty(t, storage.blocks)
+	assert.Empty(t, storage.logs)
+	assert.Empty(t, storage.buckets)
+	assert.Empty(t, storage.tats)
+}
+
+func TestMemoryStorage_ResetIPv6(t *testing.T) {
+	storage, _ := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	// The keys of ip:2001:db8::1:5 start with ip:2001:db8::1 and a colon too
+	for _, key := range []string{"ip:2001:db8::1", "ip:2001:db8::1:5"} {
+		storage.Increment(ctx, key+":1s:0", time.Minute)
+		storage.AddRequest(ctx, key, []Counter{{Key: key + ":1s", Window: time.Second, Limit: 10}})
+		storage.TakeToken(ctx, key, []Bucket{{Key: key + ":1s", Burst: 10, Interval: 100 * time.Millisecond}})
+		storage.ApplyGCRA(ctx, key, []Bucket{{Key: key + ":1s", Burst: 10, Interval: 100 * time.Millisecond}})
+	}
+
+	assert.NoError(t, storage.Reset(ctx, "ip:2001:db8::1"))
+	assert.Equal(t, []string{"ip:2001:db8::1:5:1s:0"}, keysOf(storage.counters))
+	assert.Equal(t, []string{"ip:2001:db8::1:5:1s"}, keysOf(storage.logs))
+	assert.Equal(t, []string{"ip:2001:db8::1:5:1s"}, keysOf(storage.buckets))
+	assert.Equal(t, []string{"ip:2001:db8::1:5:1s"}, keysOf(storage.tats))
+}
+
+// keysOf returns the keys of entries, sorted
+func keysOf[V any](entries map[string]V) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestMemoryStorage_ConcurrentIncrement(t *testing.T) {
+	storage, _ := newTestMemoryStorage(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				storage.Increment(ctx, "token:abc123", time.Minute)
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := storage.Get(ctx, "token:abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), count)
+}
+
+func TestMemoryStorage_CloseIsIdempotent(t *testing.T) {
+	storage := NewMemoryStorage(time.Millisecond)
+
+	assert.NoError(t, storage.Close())
+	assert.NoError(t, storage.Close())
+}
@@ -0,0 +1,263 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// InvalidationBus broadcasts the keys whose cached state is stale to every
+// instance sharing a storage
+type InvalidationBus interface {
+	// PublishInvalidation tells every watcher to forget what it cached about key
+	PublishInvalidation(ctx context.Context, key string) error
+
+	// WatchInvalidations calls invalidated with every published key, or with
+	// an empty key when everything must be forgotten, until ctx is done
+	WatchInvalidations(ctx context.Context, invalidated func(key string)) error
+}
+
+// NearCacheStorage is a Storage that remembers in process memory the keys
+// blocked in the storage it wraps until their blocks expire, so that the
+// requests of a blocked client are rejected without a round-trip.
+//
+// Keys unblocked or reset through it are published on an InvalidationBus,
+// so that every instance sharing the storage forgets their blocks. Without
+// a bus, a key unblocked by another instance stays blocked here until its
+// block expires. It is safe for concurrent use.
+type NearCacheStorage struct {
+	next Storage
+	bus  InvalidationBus
+
+	mu     sync.Mutex
+	blocks map[string]time.Time
+	now    func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewNearCacheStorage wraps next with a cache of its blocked keys, watching
+// bus for invalidations until Close is called. A nil bus only invalidates
+// the keys unblocked or reset through this instance.
+func NewNearCacheStorage(next Storage, bus InvalidationBus) *NearCacheStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &NearCacheStorage{
+		next:   next,
+		bus:    bus,
+		blocks: make(map[string]time.Time),
+		now:    time.Now,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go c.run(ctx)
+
+	return c
+}
+
+// run evicts expired blocks and applies the invalidations of the bus until
+// ctx is done
+func (c *NearCacheStorage) run(ctx context.Context) {
+	defer close(c.done)
+
+	if c.bus != nil {
+		go func() {
+			err := c.bus.WatchInvalidations(ctx, c.invalidate)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Stopped watching block invalidations: %v", err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(DefaultCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.evictExpired()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cached returns the remaining time of the cached block of key, or zero
+func (c *NearCacheStorage) cached(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt, exists := c.blocks[key]
+	if !exists {
+		return 0
+	}
+
+	ttl := expiresAt.Sub(c.now())
+	if ttl <= 0 {
+		delete(c.blocks, key)
+		return 0
+	}
+
+	return ttl
+}
+
+// remember caches a block of key expiring after ttl. Blocks without a
+// known expiry are not cached, as their end would never be seen.
+func (c *NearCacheStorage) remember(key string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.blocks[key] = c.now().Add(ttl)
+}
+
+// invalidate forgets the cached block of key, or of every key when key is empty
+func (c *NearCacheStorage) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key == "" {
+		c.blocks = make(map[string]time.Time)
+		return
+	}
+	delete(c.blocks, key)
+}
+
+// evictExpired forgets the blocks that have expired
+func (c *NearCacheStorage) evictExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, expiresAt := range c.blocks {
+		if !now.Before(expiresAt) {
+			delete(c.blocks, key)
+		}
+	}
+}
+
+// Increment increments the request count for the given key
+func (c *NearCacheStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return c.next.Increment(ctx, key, window)
+}
+
+// Get retrieves the current count for the given key
+func (c *NearCacheStorage) Get(ctx context.Context, key string) (int64, error) {
+	return c.next.Get(ctx, key)
+}
+
+// SetBlock sets a block for the given key and caches it
+func (c *NearCacheStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
+	if err := c.next.SetBlock(ctx, key, duration); err != nil {
+		return err
+	}
+
+	c.remember(key, duration)
+	return nil
+}
+
+// IsBlocked answers from the cache when the key is known to be blocked
+func (c *NearCacheStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
+	ttl, err := c.BlockTTL(ctx, key)
+	return ttl != 0, err
+}
+
+// BlockTTL answers from the cache when the key is known to be blocked
+func (c *NearCacheStorage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	if ttl := c.cached(key); ttl > 0 {
+		return ttl, nil
+	}
+
+	ttl, err := c.next.BlockTTL(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	c.remember(key, ttl)
+	return ttl, nil
+}
+
+// Hit rejects a key known to be blocked without counting it, as the
+// storage would, and caches the blocks it reports
+func (c *NearCacheStorage) Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error) {
+	if ttl := c.cached(key); ttl > 0 {
+		return &HitResult{Blocked: true, BlockTTL: ttl}, nil
+	}
+
+	result, err := c.next.Hit(ctx, key, counters, blockDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Blocked {
+		c.remember(key, result.BlockTTL)
+	}
+	return result, nil
+}
+
+// AddRequest records a request in the sliding window logs of the given key
+func (c *NearCacheStorage) AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error) {
+	return c.next.AddRequest(ctx, key, logs)
+}
+
+// TakeToken takes one token from the buckets of the given key
+func (c *NearCacheStorage) TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error) {
+	return c.next.TakeToken(ctx, key, buckets)
+}
+
+// ApplyGCRA checks a request against the theoretical arrival times of the given key
+func (c *NearCacheStorage) ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error) {
+	return c.next.ApplyGCRA(ctx, key, buckets)
+}
+
+// Unblock removes the block of the given key and invalidates it everywhere
+func (c *NearCacheStorage) Unblock(ctx context.Context, key string) error {
+	if err := c.next.Unblock(ctx, key); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, key)
+}
+
+// Reset removes the block and every counter of the given key and
+// invalidates it everywhere
+func (c *NearCacheStorage) Reset(ctx context.Context, key string) error {
+	if err := c.next.Reset(ctx, key); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, key)
+}
+
+// publish invalidates key here and on the bus
+func (c *NearCacheStorage) publish(ctx context.Context, key string) error {
+	c.invalidate(key)
+
+	if c.bus == nil {
+		return nil
+	}
+	if err := c.bus.PublishInvalidation(ctx, key); err != nil {
+		return fmt.Errorf("key changed but other instances may keep it cached: %w", err)
+	}
+
+	return nil
+}
+
+// BlockedKeys lists the blocked keys of the storage
+func (c *NearCacheStorage) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	return c.next.BlockedKeys(ctx)
+}
+
+// Close stops watching for invalidations and closes the storage
+func (c *NearCacheStorage) Close() error {
+	c.cancel()
+	<-c.done
+
+	return c.next.Close()
+}
@@ -0,0 +1,144 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeInvalidationBus is an in-memory InvalidationBus shared by the caches
+// of a test, like the instances sharing a Redis
+type fakeInvalidationBus struct {
+	mu       sync.Mutex
+	watchers []func(key string)
+}
+
+func (b *fakeInvalidationBus) PublishInvalidation(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, invalidated := range b.watchers {
+		invalidated(key)
+	}
+	return nil
+}
+
+func (b *fakeInvalidationBus) WatchInvalidations(ctx context.Context, invalidated func(key string)) error {
+	b.mu.Lock()
+	b.watchers = append(b.watchers, invalidated)
+	b.mu.Unlock()
+
+	<-ctx.Done()
+	return nil
+}
+
+// watching reports whether n caches are watching the bus
+func (b *fakeInvalidationBus) watching(n int) func() bool {
+	return func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.watchers) == n
+	}
+}
+
+func TestNearCacheStorage_RemembersBlocks(t *testing.T) {
+	mockStorage := new(MockStorage)
+	cache := NewNearCacheStorage(mockStorage, nil)
+	now := testNow
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	counters := secondCounter("ip:192.168.1.1", 10)
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", counters, time.Minute).
+		Return(&HitResult{Counts: []int64{11}, Blocked: true, BlockTTL: time.Minute}, nil).Once()
+
+	result, err := cache.Hit(ctx, "ip:192.168.1.1", counters, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{11}, result.Counts)
+
+	// Until the block expires the storage is not asked again
+	now = now.Add(20 * time.Second)
+	result, err = cache.Hit(ctx, "ip:192.168.1.1", counters, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, &HitResult{Blocked: true, BlockTTL: 40 * time.Second}, result)
+
+	ttl, err := cache.BlockTTL(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, 40*time.Second, ttl)
+
+	blocked, err := cache.IsBlocked(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	// An escalated block replaces the cached one
+	mockStorage.On("SetBlock", ctx, "ip:192.168.1.1", 5*time.Minute).Return(nil)
+	assert.NoError(t, cache.SetBlock(ctx, "ip:192.168.1.1", 5*time.Minute))
+
+	now = now.Add(5 * time.Minute)
+	mockStorage.On("BlockTTL", ctx, "ip:192.168.1.1").Return(time.Duration(0), nil)
+	ttl, err = cache.BlockTTL(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.Zero(t, ttl)
+
+	mockStorage.AssertExpectations(t)
+
+	mockStorage.On("Close").Return(nil)
+	assert.NoError(t, cache.Close())
+}
+
+func TestNearCacheStorage_UnblockInvalidatesEveryInstance(t *testing.T) {
+	bus := &fakeInvalidationBus{}
+	mockStorage := new(MockStorage)
+	ctx := context.Background()
+
+	first := NewNearCacheStorage(mockStorage, bus)
+	second := NewNearCacheStorage(mockStorage, bus)
+	assert.Eventually(t, bus.watching(2), time.Second, time.Millisecond)
+
+	mockStorage.On("SetBlock", ctx, "ip:192.168.1.1", time.Minute).Return(nil)
+	assert.NoError(t, first.SetBlock(ctx, "ip:192.168.1.1", time.Minute))
+	assert.NoError(t, second.SetBlock(ctx, "ip:192.168.1.1", time.Minute))
+
+	// Unblocking through one instance makes both ask the storage again
+	mockStorage.On("Unblock", ctx, "ip:192.168.1.1").Return(nil)
+	assert.NoError(t, first.Unblock(ctx, "ip:192.168.1.1"))
+
+	mockStorage.On("BlockTTL", ctx, "ip:192.168.1.1").Return(time.Duration(0), nil)
+	for _, cache := range []*NearCacheStorage{first, second} {
+		blocked, err := cache.IsBlocked(ctx, "ip:192.168.1.1")
+		assert.NoError(t, err)
+		assert.False(t, blocked)
+	}
+	mockStorage.AssertNumberOfCalls(t, "BlockTTL", 2)
+
+	// An empty key forgets everything, e.g. after a reconnection
+	assert.NoError(t, second.SetBlock(ctx, "ip:192.168.1.1", time.Minute))
+	second.invalidate("")
+	_, err := second.BlockTTL(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	mockStorage.AssertNumberOfCalls(t, "BlockTTL", 3)
+
+	mockStorage.On("Close").Return(nil)
+	assert.NoError(t, first.Close())
+	assert.NoError(t, second.Close())
+}
+
+func TestNearCacheStorage_UnknownExpiryNotCached(t *testing.T) {
+	mockStorage := new(MockStorage)
+	cache := NewNearCacheStorage(mockStorage, nil)
+	ctx := context.Background()
+
+	mockStorage.On("BlockTTL", ctx, "ip:192.168.1.1").Return(time.Duration(-1), nil)
+	for i := 0; i < 2; i++ {
+		blocked, err := cache.IsBlocked(ctx, "ip:192.168.1.1")
+		assert.NoError(t, err)
+		assert.True(t, blocked)
+	}
+	mockStorage.AssertNumberOfCalls(t, "BlockTTL", 2)
+
+	mockStorage.On("Close").Return(nil)
+	assert.NoError(t, cache.Close())
+}
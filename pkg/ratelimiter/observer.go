@@ -0,0 +1,11 @@
+package ratelimiter
+
+import "time"
+
+// Observer is notified of the decisions of a RateLimiter
+type Observer interface {
+	// ObserveDecision is called after every CheckLimit with its result or
+	// error and how long it took. namespace is the Config.Namespace of the
+	// limiter that decided.
+	ObserveDecision(namespace string, result *LimitResult, err error, elapsed time.Duration)
+}
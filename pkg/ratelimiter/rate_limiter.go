@@ -3,16 +3,36 @@ package ratelimiter
 import (
 	"context"
 	"fmt"
+	"math"
+	"net/netip"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DefaultOffenceDecay is how long offences are remembered when block
+// escalation is enabled without an OffenceDecay
+const DefaultOffenceDecay = 24 * time.Hour
+
 // RateLimiter handles rate limiting logic
 type RateLimiter struct {
-	storage       Storage
-	defaultIPLimit    int
-	defaultTokenLimit int
-	blockDuration     time.Duration
-	tokenLimits       map[string]int
+	storage            Storage
+	algorithm          Algorithm
+	identityMode       IdentityMode
+	ipv4Prefix         int
+	ipv6Prefix         int
+	accessList         *AccessList
+	namespace          string
+	defaultIPLimits    []Limit
+	defaultTokenLimits []Limit
+	blockDuration      time.Duration
+	blockEscalation    []time.Duration
+	offenceDecay       time.Duration
+	tokenRegistry      TokenRegistry
+	unknownTokens      UnknownTokenPolicy
+	observer           Observer
+	tracer             trace.Tracer
+	now                func() time.Time
 }
 
 // LimitResult represents the result of a rate limit check
@@ -21,98 +41,643 @@ type LimitResult struct {
 	Remaining int
 	ResetTime time.Time
 	Blocked   bool
+
+	// Rule is the limit that rejected the request or, when the request is
+	// allowed, the most restrictive limit. Remaining and ResetTime refer to it.
+	// It is the zero Limit when the key was already blocked.
+	Rule Limit
+
+	// Limit is the number of requests allowed by Rule or, when the key was
+	// already blocked, by the identity's first limit
+	Limit int
+
+	// RetryAfter is how long a rejected request should wait before retrying.
+	// It is zero for allowed requests.
+	RetryAfter time.Duration
+
+	// Key is the identity the result refers to, such as "ip:192.168.1.1"
+	Key string
+
+	// Reason is set when the request was decided by the access list
+	// instead of the limits
+	Reason Reason
+
+	// DryRun is set when the request was checked under DryRun, so a
+	// rejection is only reported and not enforced
+	DryRun bool
 }
 
 // Config represents rate limiter configuration
+// Each identity may have several limits, e.g. a per-second burst limit
+// combined with a daily quota; a request must satisfy all of them.
+// Limits of the same identity are told apart by their window, so each
+// window may appear only once.
 type Config struct {
-	DefaultIPLimit    int
-	DefaultTokenLimit int
-	BlockDuration     time.Duration
-	TokenLimits       map[string]int
+	Algorithm          Algorithm
+	DefaultIPLimits    []Limit
+	DefaultTokenLimits []Limit
+	BlockDuration      time.Duration
+	TokenLimits        map[string][]Limit
+
+	// BlockEscalation replaces BlockDuration with increasing block durations
+	// for repeat offenders, e.g. 10s, 1m, 5m, 1h: a key blocked for the nth
+	// time within OffenceDecay is blocked for the nth duration, or the last
+	// one once they run out. Empty means every block lasts BlockDuration.
+	BlockEscalation []time.Duration
+
+	// OffenceDecay is how long a block counts as an offence of its key.
+	// Zero means DefaultOffenceDecay.
+	OffenceDecay time.Duration
+
+	// IdentityMode selects whether requests are limited by token, IP or
+	// both. The zero value is IdentityToken.
+	IdentityMode IdentityMode
+
+	// IPv4Prefix and IPv6Prefix mask client addresses to a network before
+	// building the IP key, so e.g. every address of an IPv6 /64 shares one
+	// budget. Zero keeps the full address.
+	IPv4Prefix int
+	IPv6Prefix int
+
+	// AccessList allowlists and denylists client IPs before any limit is
+	// checked. Nil means no access list.
+	AccessList *AccessList
+
+	// TokenRegistry looks up API tokens instead of TokenLimits when set
+	TokenRegistry TokenRegistry
+
+	// UnknownTokens selects how tokens missing from the registry are
+	// limited. The zero value is UnknownTokenDefault.
+	UnknownTokens UnknownTokenPolicy
+
+	// Namespace prefixes every key, so limiters sharing a storage keep
+	// separate counters. Empty means no prefix.
+	Namespace string
+
+	// Observer is notified of every decision, e.g. to record metrics.
+	// Nil means no observer.
+	Observer Observer
+
+	// TracerProvider creates the spans of CheckLimit. Nil means no spans.
+	TracerProvider trace.TracerProvider
 }
 
 // New creates a new RateLimiter instance
+// Limits without a window are treated as requests per second
 func New(storage Storage, config Config) *RateLimiter {
+	offenceDecay := config.OffenceDecay
+	if offenceDecay <= 0 {
+		offenceDecay = DefaultOffenceDecay
+	}
+
+	tokenRegistry := config.TokenRegistry
+	if tokenRegistry == nil {
+		tokenLimits := make(StaticTokenRegistry, len(config.TokenLimits))
+		for token, limits := range config.TokenLimits {
+			tokenLimits[token] = limits
+		}
+		tokenRegistry = tokenLimits
+	}
+
+	var tracer trace.Tracer
+	if config.TracerProvider != nil {
+		tracer = config.TracerProvider.Tracer(tracerName)
+	}
+
 	return &RateLimiter{
-		storage:           storage,
-		defaultIPLimit:    config.DefaultIPLimit,
-		defaultTokenLimit: config.DefaultTokenLimit,
-		blockDuration:     config.BlockDuration,
-		tokenLimits:       config.TokenLimits,
+		storage:            storage,
+		algorithm:          config.Algorithm,
+		identityMode:       config.IdentityMode,
+		ipv4Prefix:         config.IPv4Prefix,
+		ipv6Prefix:         config.IPv6Prefix,
+		accessList:         config.AccessList,
+		namespace:          config.Namespace,
+		defaultIPLimits:    withDefaultWindow(config.DefaultIPLimits),
+		defaultTokenLimits: withDefaultWindow(config.DefaultTokenLimits),
+		blockDuration:      config.BlockDuration,
+		blockEscalation:    config.BlockEscalation,
+		offenceDecay:       offenceDecay,
+		tokenRegistry:      tokenRegistry,
+		unknownTokens:      config.UnknownTokens,
+		observer:           config.Observer,
+		tracer:             tracer,
+		now:                time.Now,
 	}
 }
 
-// CheckLimit checks if a request should be allowed based on IP or token
+// withDefaultWindow gives the limits without a window a window of one second
+func withDefaultWindow(limits []Limit) []Limit {
+	normalized := make([]Limit, len(limits))
+	for i, limit := range limits {
+		if limit.Window <= 0 {
+			limit.Window = time.Second
+		}
+		normalized[i] = limit
+	}
+	return normalized
+}
+
+// ruleResult is the outcome of checking a request against a single limit
+type ruleResult struct {
+	limit     Limit
+	allowed   bool
+	remaining int
+	resetTime time.Time
+}
+
+// CheckLimit checks if a request should be allowed based on IP or token,
+// as selected by the identity mode
+// The request is rejected if any of the identity's limits is exceeded
+// Allowlisted and denylisted IPs are decided without touching the storage.
 func (rl *RateLimiter) CheckLimit(ctx context.Context, ip, token string) (*LimitResult, error) {
-	// Determine which key and limit to use
-	key, limit := rl.getKeyAndLimit(ip, token)
-	
-	// Check if the key is currently blocked
-	blocked, err := rl.storage.IsBlocked(ctx, key)
+	var span trace.Span
+	if rl.tracer != nil {
+		ctx, span = rl.tracer.Start(ctx, "ratelimiter.CheckLimit")
+		defer span.End()
+	}
+
+	start := time.Now()
+	result, err := rl.checkLimit(ctx, ip, token)
+	if result != nil && ctx.Value(dryRunKey{}) != nil {
+		result.DryRun = true
+	}
+	if rl.observer != nil {
+		rl.observer.ObserveDecision(rl.namespace, result, err, time.Since(start))
+	}
+	if span != nil {
+		rl.annotateSpan(span, result, err)
+	}
+
+	return result, err
+}
+
+// dryRunKey marks the contexts of DryRun
+type dryRunKey struct{}
+
+// DryRun returns a context under which CheckLimit counts requests as usual
+// but never blocks the keys exceeding their limits, for shadow mode. Blocks
+// set before are still reported, and the results are marked DryRun.
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// checkLimit is CheckLimit without the observer and span
+func (rl *RateLimiter) checkLimit(ctx context.Context, ip, token string) (*LimitResult, error) {
+	if rl.accessList != nil {
+		switch reason := rl.accessList.Check(ip); reason {
+		case ReasonAllowlisted:
+			return &LimitResult{Allowed: true, Reason: reason}, nil
+		case ReasonDenylisted:
+			return &LimitResult{Allowed: false, Reason: reason}, nil
+		}
+	}
+
+	switch rl.identityMode {
+	case "", IdentityToken:
+		// Determine which key and limits to use
+		key, limits, err := rl.getKeyAndLimits(ctx, ip, token)
+		if err != nil {
+			return nil, err
+		}
+		return rl.checkKey(ctx, key, limits)
+	case IdentityIP:
+		key, limits, _ := rl.getKeyAndLimits(ctx, ip, "")
+		return rl.checkKey(ctx, key, limits)
+	case IdentityIPAndToken:
+		return rl.checkIPAndToken(ctx, ip, token)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIdentityMode, rl.identityMode)
+	}
+}
+
+// checkIPAndToken checks the request against the token limits and then, if
+// the token allows it, against the IP limits. The IP is not charged for
+// requests its token already rejects, so a client retrying with a blocked
+// token cannot get the IP, possibly shared behind a NAT, blocked as well.
+func (rl *RateLimiter) checkIPAndToken(ctx context.Context, ip, token string) (*LimitResult, error) {
+	tokenKey, tokenLimits, err := rl.getKeyAndLimits(ctx, ip, token)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check block status: %w", err)
+		return nil, err
+	}
+
+	ipKey, ipLimits, _ := rl.getKeyAndLimits(ctx, ip, "")
+	if tokenKey == ipKey {
+		return rl.checkKey(ctx, ipKey, ipLimits)
+	}
+
+	tokenResult, err := rl.checkKey(ctx, tokenKey, tokenLimits)
+	if err != nil || !tokenResult.Allowed {
+		return tokenResult, err
+	}
+
+	ipResult, err := rl.checkKey(ctx, ipKey, ipLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report the identity that rejected the request or has the fewest
+	// requests left
+	if !ipResult.Allowed || ipResult.Remaining <= tokenResult.Remaining {
+		return ipResult, nil
+	}
+
+	return tokenResult, nil
+}
+
+// checkKey checks the request against the limits of a single identity key
+// with the configured algorithm
+func (rl *RateLimiter) checkKey(ctx context.Context, key string, limits []Limit) (*LimitResult, error) {
+	var (
+		result *LimitResult
+		err    error
+	)
+
+	switch rl.algorithm {
+	case "", FixedWindow:
+		result, err = rl.checkFixedWindow(ctx, key, limits)
+	case SlidingWindow:
+		result, err = rl.checkSlidingWindow(ctx, key, limits)
+	case TokenBucket:
+		result, err = rl.checkRules(ctx, key, limits, rl.takeTokens)
+	case GCRA:
+		result, err = rl.checkRules(ctx, key, limits, rl.applyGCRA)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, rl.algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Key = key
+	result.Limit = result.Rule.Requests
+	if result.Rule == (Limit{}) && len(limits) > 0 {
+		result.Limit = limits[0].Requests
+	}
+	if !result.Allowed {
+		result.RetryAfter = max(result.ResetTime.Sub(rl.now()), 0)
+	}
+
+	return result, nil
+}
+
+// checkFixedWindow counts the request in the window containing the current
+// time of every limit, or blocks the key when any would be exceeded, all in a
+// single atomic storage operation. Each window has its own counter, so it
+// resets exactly when the window ends.
+func (rl *RateLimiter) checkFixedWindow(ctx context.Context, key string, limits []Limit) (*LimitResult, error) {
+	now := rl.now()
+
+	counters := make([]Counter, len(limits))
+	for i, limit := range limits {
+		counters[i] = Counter{
+			Key:    limit.counterKey(key, now),
+			Window: limit.Window,
+			Limit:  int64(limit.Requests),
+		}
+	}
+
+	hit, err := rl.storage.Hit(ctx, key, counters, rl.firstBlockDuration(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
 	}
-	
-	if blocked {
+
+	// The key was already blocked, nothing was counted
+	if len(hit.Counts) == 0 && hit.Blocked {
+		// A block without expiry has no known end
+		blockTTL := hit.BlockTTL
+		if blockTTL < 0 {
+			blockTTL = rl.blockDuration
+		}
+
 		return &LimitResult{
 			Allowed:   false,
 			Remaining: 0,
-			ResetTime: time.Now().Add(rl.blockDuration),
+			ResetTime: now.Add(blockTTL),
 			Blocked:   true,
 		}, nil
 	}
-	
-	// Increment the request count
-	count, err := rl.storage.Increment(ctx, key, time.Second)
+
+	results := make([]ruleResult, len(limits))
+	for i, limit := range limits {
+		remaining := limit.Requests - int(hit.Counts[i])
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		results[i] = ruleResult{
+			limit:     limit,
+			allowed:   hit.Counts[i] <= int64(limit.Requests),
+			remaining: remaining,
+			resetTime: limit.windowStart(now).Add(limit.Window),
+		}
+	}
+
+	result := combineResults(results)
+	if hit.Blocked {
+		// Repeat offenders are blocked for longer than the first block Hit set
+		blockDuration, err := rl.recordOffence(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if blockDuration != hit.BlockTTL {
+			if err := rl.storage.SetBlock(ctx, key, blockDuration); err != nil {
+				return nil, fmt.Errorf("failed to set block: %w", err)
+			}
+		}
+
+		result.Blocked = true
+		result.ResetTime = now.Add(blockDuration)
+	}
+
+	return result, nil
+}
+
+// checkSlidingWindow records the request in the sliding window log of every
+// limit, or blocks the key when any would be exceeded
+func (rl *RateLimiter) checkSlidingWindow(ctx context.Context, key string, limits []Limit) (*LimitResult, error) {
+	// Check if the key is currently blocked
+	blockTTL, err := rl.storage.BlockTTL(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment counter: %w", err)
+		return nil, fmt.Errorf("failed to check block status: %w", err)
 	}
-	
-	// Check if limit is exceeded
-	if count > int64(limit) {
-		// Block the key
-		if err := rl.storage.SetBlock(ctx, key, rl.blockDuration); err != nil {
-			return nil, fmt.Errorf("failed to set block: %w", err)
+
+	if blockTTL != 0 {
+		// A block without expiry has no known end
+		if blockTTL < 0 {
+			blockTTL = rl.blockDuration
 		}
-		
+
 		return &LimitResult{
 			Allowed:   false,
 			Remaining: 0,
-			ResetTime: time.Now().Add(rl.blockDuration),
+			ResetTime: rl.now().Add(blockTTL),
 			Blocked:   true,
 		}, nil
 	}
-	
-	remaining := limit - int(count)
-	if remaining < 0 {
-		remaining = 0
+
+	logs := make([]Counter, len(limits))
+	for i, limit := range limits {
+		logs[i] = Counter{Key: limit.key(key), Window: limit.Window, Limit: int64(limit.Requests)}
 	}
-	
-	return &LimitResult{
-		Allowed:   true,
-		Remaining: remaining,
-		ResetTime: time.Now().Add(time.Second),
-		Blocked:   false,
-	}, nil
+
+	// Record the request
+	counts, err := rl.storage.AddRequest(ctx, key, logs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment counter: %w", err)
+	}
+
+	results := make([]ruleResult, len(limits))
+	for i, limit := range limits {
+		remaining := limit.Requests - int(counts[i])
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		results[i] = ruleResult{
+			limit:     limit,
+			allowed:   counts[i] <= int64(limit.Requests),
+			remaining: remaining,
+			resetTime: rl.now().Add(limit.Window),
+		}
+	}
+
+	result := combineResults(results)
+
+	// Check if any limit is exceeded
+	if !result.Allowed && rl.firstBlockDuration(ctx) > 0 {
+		blockDuration, err := rl.recordOffence(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// Block the key
+		if err := rl.storage.SetBlock(ctx, key, blockDuration); err != nil {
+			return nil, fmt.Errorf("failed to set block: %w", err)
+		}
+
+		result.Blocked = true
+		result.ResetTime = rl.now().Add(blockDuration)
+	}
+
+	return result, nil
 }
 
-// getKeyAndLimit determines which key and limit to use
-// Token limits have priority over IP limits
-func (rl *RateLimiter) getKeyAndLimit(ip, token string) (string, int) {
+// firstBlockDuration returns how long a key is blocked for its first
+// offence, or zero when ctx does not allow blocking
+func (rl *RateLimiter) firstBlockDuration(ctx context.Context) time.Duration {
+	if ctx.Value(dryRunKey{}) != nil {
+		return 0
+	}
+	if len(rl.blockEscalation) > 0 {
+		return rl.blockEscalation[0]
+	}
+	return rl.blockDuration
+}
+
+// recordOffence records that key was blocked and returns how long the block
+// should last given the key's offences within the offence decay. Offences
+// are kept in a sliding window log, so each one is forgiven on its own once
+// it is older than the decay.
+func (rl *RateLimiter) recordOffence(ctx context.Context, key string) (time.Duration, error) {
+	if len(rl.blockEscalation) == 0 {
+		return rl.blockDuration, nil
+	}
+
+	// Every offence is recorded, however many there are
+	offences, err := rl.storage.AddRequest(ctx, key, []Counter{{
+		Key:    fmt.Sprintf("%s:offences", key),
+		Window: rl.offenceDecay,
+		Limit:  math.MaxInt64,
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record offence: %w", err)
+	}
+
+	step := min(int(offences[0]), len(rl.blockEscalation)) - 1
+	return rl.blockEscalation[max(step, 0)], nil
+}
+
+// checkRules checks the request against every limit with check, which only
+// charges the limits when all of them allow the request, and combines the
+// outcomes; these algorithms reject without blocking the key
+func (rl *RateLimiter) checkRules(ctx context.Context, key string, limits []Limit, check func(context.Context, string, []Limit) ([]ruleResult, error)) (*LimitResult, error) {
+	// A limit that allows no request rejects every request without charging the others
+	for _, limit := range limits {
+		if limit.Requests <= 0 || limit.burst() <= 0 {
+			return combineResults([]ruleResult{{limit: limit, resetTime: rl.now()}}), nil
+		}
+	}
+
+	if len(limits) == 0 {
+		return combineResults(nil), nil
+	}
+
+	results, err := check(ctx, key, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return combineResults(results), nil
+}
+
+// takeTokens takes a token from the bucket of every limit, which gains a
+// token every limit interval up to the limit's burst
+func (rl *RateLimiter) takeTokens(ctx context.Context, key string, limits []Limit) ([]ruleResult, error) {
+	states, err := rl.storage.TakeToken(ctx, key, buckets(key, limits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token: %w", err)
+	}
+
+	results := make([]ruleResult, len(limits))
+	for i, limit := range limits {
+		results[i] = ruleResult{
+			limit:     limit,
+			allowed:   states[i].Allowed,
+			remaining: int(states[i].Tokens),
+			resetTime: rl.now().Add(states[i].NextToken),
+		}
+	}
+
+	return results, nil
+}
+
+// applyGCRA checks the request against the theoretical arrival time of every
+// limit, spacing requests by the limit interval with bursts of up to the
+// limit's burst
+func (rl *RateLimiter) applyGCRA(ctx context.Context, key string, limits []Limit) ([]ruleResult, error) {
+	states, err := rl.storage.ApplyGCRA(ctx, key, buckets(key, limits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply GCRA: %w", err)
+	}
+
+	results := make([]ruleResult, len(limits))
+	for i, limit := range limits {
+		// A rejected request may be retried exactly when it would conform
+		resetAfter := states[i].ResetAfter
+		if !states[i].Allowed {
+			resetAfter = states[i].RetryAfter
+		}
+
+		results[i] = ruleResult{
+			limit:     limit,
+			allowed:   states[i].Allowed,
+			remaining: int(states[i].Remaining),
+			resetTime: rl.now().Add(resetAfter),
+		}
+	}
+
+	return results, nil
+}
+
+// buckets returns the token bucket or GCRA state of every limit of key
+func buckets(key string, limits []Limit) []Bucket {
+	buckets := make([]Bucket, len(limits))
+	for i, limit := range limits {
+		buckets[i] = Bucket{Key: limit.key(key), Burst: int64(limit.burst()), Interval: limit.interval()}
+	}
+	return buckets
+}
+
+// combineResults merges per-limit outcomes into a single result.
+// A rejected request reports the rejecting limit that resets last, since the
+// request cannot succeed before then; an allowed request reports the limit
+// with the fewest remaining requests.
+func combineResults(results []ruleResult) *LimitResult {
+	combined := &LimitResult{Allowed: true}
+
+	var chosen *ruleResult
+	for i := range results {
+		r := &results[i]
+
+		switch {
+		case !r.allowed:
+			if combined.Allowed || r.resetTime.After(chosen.resetTime) {
+				chosen = r
+			}
+			combined.Allowed = false
+		case combined.Allowed:
+			if chosen == nil || r.remaining < chosen.remaining {
+				chosen = r
+			}
+		}
+	}
+
+	if chosen == nil {
+		return combined
+	}
+
+	combined.Rule = chosen.limit
+	combined.Remaining = chosen.remaining
+	combined.ResetTime = chosen.resetTime
+	if !combined.Allowed {
+		combined.Remaining = 0
+	}
+
+	return combined
+}
+
+// getKeyAndLimits determines which key and limits to use
+// Token limits have priority over IP limits. A token missing from the
+// registry is handled according to the unknown token policy.
+func (rl *RateLimiter) getKeyAndLimits(ctx context.Context, ip, token string) (string, []Limit, error) {
 	if token != "" {
-		// Check if there's a specific limit for this token
-		if limit, exists := rl.tokenLimits[token]; exists {
-			return fmt.Sprintf("token:%s", token), limit
+		limits, known, err := rl.tokenRegistry.Lookup(ctx, token)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to look up token: %w", err)
+		}
+
+		switch {
+		case known && len(limits) > 0:
+			// Use the limits specific to this token
+			return rl.namespaced(fmt.Sprintf("token:%s", token)), withDefaultWindow(limits), nil
+		case known || rl.unknownTokens == "" || rl.unknownTokens == UnknownTokenDefault:
+			// Use default token limits
+			return rl.namespaced(fmt.Sprintf("token:%s", token)), rl.defaultTokenLimits, nil
+		case rl.unknownTokens == UnknownTokenReject:
+			return "", nil, ErrUnknownToken
 		}
-		// Use default token limit
-		return fmt.Sprintf("token:%s", token), rl.defaultTokenLimit
+		// UnknownTokenIP falls back to IP-based limiting
 	}
-	
+
 	// Use IP-based limiting
-	return fmt.Sprintf("ip:%s", ip), rl.defaultIPLimit
+	return rl.namespaced(fmt.Sprintf("ip:%s", rl.network(ip))), rl.defaultIPLimits, nil
+}
+
+// network masks ip to the configured prefix length of its family. Values
+// that are not IP addresses are returned unchanged.
+func (rl *RateLimiter) network(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+
+	bits := rl.ipv6Prefix
+	if addr.Is4() {
+		bits = rl.ipv4Prefix
+	}
+
+	if bits <= 0 || bits >= addr.BitLen() {
+		return addr.String()
+	}
+
+	prefix, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+
+	return prefix.String()
+}
+
+// namespaced prefixes key with the limiter's namespace, if any
+func (rl *RateLimiter) namespaced(key string) string {
+	if rl.namespace == "" {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", rl.namespace, key)
 }
 
 // Close closes the rate limiter and its storage
 func (rl *RateLimiter) Close() error {
 	return rl.storage.Close()
-} 
\ No newline at end of file
+}
@@ -2,6 +2,8 @@ package ratelimiter
 
 import (
 	"context"
+	"fmt"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -34,26 +36,89 @@ func (m *MockStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
 	return args.Bool(0), args.Error(1)
 }
 
+func (m *MockStorage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *MockStorage) Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error) {
+	args := m.Called(ctx, key, counters, blockDuration)
+	result, _ := args.Get(0).(*HitResult)
+	return result, args.Error(1)
+}
+
+func (m *MockStorage) AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error) {
+	args := m.Called(ctx, key, logs)
+	counts, _ := args.Get(0).([]int64)
+	return counts, args.Error(1)
+}
+
+func (m *MockStorage) TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error) {
+	args := m.Called(ctx, key, buckets)
+	states, _ := args.Get(0).([]TokenBucketState)
+	return states, args.Error(1)
+}
+
+func (m *MockStorage) ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error) {
+	args := m.Called(ctx, key, buckets)
+	states, _ := args.Get(0).([]GCRAState)
+	return states, args.Error(1)
+}
+
+func (m *MockStorage) Unblock(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Reset(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	args := m.Called(ctx)
+	blocked, _ := args.Get(0).([]BlockedKey)
+	return blocked, args.Error(1)
+}
+
 func (m *MockStorage) Close() error {
 	args := m.Called()
 	return args.Error(0)
 }
 
+// testNow is the fixed current time used by limiter tests, half a second into a window
+var testNow = time.Date(2025, 1, 1, 12, 0, 0, int(500*time.Millisecond), time.UTC)
+
+func fixedNow() time.Time {
+	return testNow
+}
+
+// windowKey returns the fixed window counter key of key for the one-second window containing testNow
+func windowKey(key string) string {
+	return fmt.Sprintf("%s:1s:%d", key, testNow.Truncate(time.Second).UnixMilli())
+}
+
+// secondCounter returns the counter checked for key against a per-second limit at testNow
+func secondCounter(key string, limit int64) []Counter {
+	return []Counter{{Key: windowKey(key), Window: time.Second, Limit: limit}}
+}
+
 func TestRateLimiter_CheckLimit_IPBasedAllowed(t *testing.T) {
 	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       make(map[string]int),
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
 	}
 
 	rl := New(mockStorage, config)
+	rl.now = fixedNow
 	ctx := context.Background()
 
 	// Mock expectations
-	mockStorage.On("IsBlocked", ctx, "ip:192.168.1.1").Return(false, nil)
-	mockStorage.On("Increment", ctx, "ip:192.168.1.1", time.Second).Return(int64(5), nil)
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{5}}, nil)
 
 	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
 
@@ -61,6 +126,8 @@ func TestRateLimiter_CheckLimit_IPBasedAllowed(t *testing.T) {
 	assert.True(t, result.Allowed)
 	assert.Equal(t, 5, result.Remaining)
 	assert.False(t, result.Blocked)
+	assert.Equal(t, 10, result.Limit)
+	assert.Zero(t, result.RetryAfter)
 
 	mockStorage.AssertExpectations(t)
 }
@@ -68,19 +135,19 @@ func TestRateLimiter_CheckLimit_IPBasedAllowed(t *testing.T) {
 func TestRateLimiter_CheckLimit_IPBasedExceeded(t *testing.T) {
 	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       make(map[string]int),
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
 	}
 
 	rl := New(mockStorage, config)
+	rl.now = fixedNow
 	ctx := context.Background()
 
 	// Mock expectations
-	mockStorage.On("IsBlocked", ctx, "ip:192.168.1.1").Return(false, nil)
-	mockStorage.On("Increment", ctx, "ip:192.168.1.1", time.Second).Return(int64(11), nil)
-	mockStorage.On("SetBlock", ctx, "ip:192.168.1.1", 5*time.Minute).Return(nil)
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{11}, Blocked: true, BlockTTL: 5 * time.Minute}, nil)
 
 	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
 
@@ -88,6 +155,8 @@ func TestRateLimiter_CheckLimit_IPBasedExceeded(t *testing.T) {
 	assert.False(t, result.Allowed)
 	assert.Equal(t, 0, result.Remaining)
 	assert.True(t, result.Blocked)
+	assert.Equal(t, 10, result.Limit)
+	assert.Equal(t, 5*time.Minute, result.RetryAfter)
 
 	mockStorage.AssertExpectations(t)
 }
@@ -95,18 +164,19 @@ func TestRateLimiter_CheckLimit_IPBasedExceeded(t *testing.T) {
 func TestRateLimiter_CheckLimit_TokenBasedAllowed(t *testing.T) {
 	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       map[string]int{"abc123": 50},
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {PerSecond(50)}},
 	}
 
 	rl := New(mockStorage, config)
+	rl.now = fixedNow
 	ctx := context.Background()
 
 	// Mock expectations
-	mockStorage.On("IsBlocked", ctx, "token:abc123").Return(false, nil)
-	mockStorage.On("Increment", ctx, "token:abc123", time.Second).Return(int64(25), nil)
+	mockStorage.On("Hit", ctx, "token:abc123", secondCounter("token:abc123", 50), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{25}}, nil)
 
 	result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
 
@@ -121,19 +191,19 @@ func TestRateLimiter_CheckLimit_TokenBasedAllowed(t *testing.T) {
 func TestRateLimiter_CheckLimit_TokenBasedExceeded(t *testing.T) {
 	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       map[string]int{"abc123": 50},
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {PerSecond(50)}},
 	}
 
 	rl := New(mockStorage, config)
+	rl.now = fixedNow
 	ctx := context.Background()
 
 	// Mock expectations
-	mockStorage.On("IsBlocked", ctx, "token:abc123").Return(false, nil)
-	mockStorage.On("Increment", ctx, "token:abc123", time.Second).Return(int64(51), nil)
-	mockStorage.On("SetBlock", ctx, "token:abc123", 5*time.Minute).Return(nil)
+	mockStorage.On("Hit", ctx, "token:abc123", secondCounter("token:abc123", 50), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{51}, Blocked: true, BlockTTL: 5 * time.Minute}, nil)
 
 	result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
 
@@ -148,17 +218,19 @@ func TestRateLimiter_CheckLimit_TokenBasedExceeded(t *testing.T) {
 func TestRateLimiter_CheckLimit_AlreadyBlocked(t *testing.T) {
 	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       make(map[string]int),
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
 	}
 
 	rl := New(mockStorage, config)
+	rl.now = fixedNow
 	ctx := context.Background()
 
 	// Mock expectations
-	mockStorage.On("IsBlocked", ctx, "ip:192.168.1.1").Return(true, nil)
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Blocked: true, BlockTTL: 2 * time.Minute}, nil)
 
 	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
 
@@ -166,32 +238,703 @@ func TestRateLimiter_CheckLimit_AlreadyBlocked(t *testing.T) {
 	assert.False(t, result.Allowed)
 	assert.Equal(t, 0, result.Remaining)
 	assert.True(t, result.Blocked)
+	assert.Equal(t, testNow.Add(2*time.Minute), result.ResetTime)
+	assert.Equal(t, 2*time.Minute, result.RetryAfter)
+	assert.Equal(t, 10, result.Limit)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_AlreadyBlockedWithoutExpiry(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// A block without expiry is retried after a full block, not immediately
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Blocked: true, BlockTTL: -1}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, testNow.Add(5*time.Minute), result.ResetTime)
+	assert.Equal(t, 5*time.Minute, result.RetryAfter)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_SlidingWindow(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		Algorithm:          SlidingWindow,
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Mock expectations
+	mockStorage.On("BlockTTL", ctx, "ip:192.168.1.1").Return(time.Duration(0), nil)
+	mockStorage.On("AddRequest", ctx, "ip:192.168.1.1", []Counter{{Key: "ip:192.168.1.1:1s", Window: time.Second, Limit: 10}}).
+		Return([]int64{7}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 3, result.Remaining)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_SlidingWindowAlreadyBlocked(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		Algorithm:          SlidingWindow,
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// The reset time is when the block actually ends, not a full block from now
+	mockStorage.On("BlockTTL", ctx, "ip:192.168.1.1").Return(90*time.Second, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, testNow.Add(90*time.Second), result.ResetTime)
+	assert.Equal(t, 90*time.Second, result.RetryAfter)
+
+	mockStorage.AssertExpectations(t)
+	mockStorage.AssertNotCalled(t, "AddRequest", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRateLimiter_CheckLimit_TokenBucket(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		Algorithm:          TokenBucket,
+		DefaultIPLimits:    []Limit{Limit{Requests: 10, Window: time.Second, Burst: 20}},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {{Requests: 50, Window: time.Second, Burst: 200}}},
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Mock expectations
+	mockStorage.On("TakeToken", ctx, "ip:192.168.1.1", []Bucket{{Key: "ip:192.168.1.1:1s", Burst: 20, Interval: 100 * time.Millisecond}}).
+		Return([]TokenBucketState{{Allowed: true, Tokens: 19, NextToken: 100 * time.Millisecond}}, nil)
+	mockStorage.On("TakeToken", ctx, "token:abc123", []Bucket{{Key: "token:abc123:1s", Burst: 200, Interval: 20 * time.Millisecond}}).
+		Return([]TokenBucketState{{Allowed: false, Tokens: 0, NextToken: 5 * time.Millisecond}}, nil)
+	mockStorage.On("TakeToken", ctx, "token:xyz789", []Bucket{{Key: "token:xyz789:1s", Burst: 100, Interval: 10 * time.Millisecond}}).
+		Return([]TokenBucketState{{Allowed: true, Tokens: 99, NextToken: 10 * time.Millisecond}}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 19, result.Remaining)
+
+	// An empty bucket rejects without blocking the key
+	result, err = rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, testNow.Add(5*time.Millisecond), result.ResetTime)
+
+	// Tokens without a specific burst default to their limit
+	result, err = rl.CheckLimit(ctx, "192.168.1.1", "xyz789")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 99, result.Remaining)
 
 	mockStorage.AssertExpectations(t)
+	mockStorage.AssertNotCalled(t, "SetBlock", mock.Anything, mock.Anything, mock.Anything)
 }
 
-func TestRateLimiter_GetKeyAndLimit(t *testing.T) {
+func TestRateLimiter_CheckLimit_GCRA(t *testing.T) {
+	mockStorage := new(MockStorage)
 	config := Config{
-		DefaultIPLimit:    10,
-		DefaultTokenLimit: 100,
-		BlockDuration:     5 * time.Minute,
-		TokenLimits:       map[string]int{"abc123": 50},
+		Algorithm:          GCRA,
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Mock expectations
+	mockStorage.On("ApplyGCRA", ctx, "ip:192.168.1.1", []Bucket{{Key: "ip:192.168.1.1:1s", Burst: 10, Interval: 100 * time.Millisecond}}).
+		Return([]GCRAState{{Allowed: false, RetryAfter: 30 * time.Millisecond, ResetAfter: time.Second}}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, testNow.Add(30*time.Millisecond), result.ResetTime)
+
+	mockStorage.AssertExpectations(t)
+	mockStorage.AssertNotCalled(t, "BlockTTL", mock.Anything, mock.Anything)
+}
+
+func TestRateLimiter_CheckLimit_UnknownAlgorithm(t *testing.T) {
+	rl := New(new(MockStorage), Config{Algorithm: "leaky_bucket", DefaultIPLimits: []Limit{PerSecond(10)}})
+
+	_, err := rl.CheckLimit(context.Background(), "192.168.1.1", "")
+
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestRateLimiter_GetKeyAndLimits(t *testing.T) {
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {PerSecond(50)}},
 	}
 
 	rl := New(nil, config)
+	ctx := context.Background()
 
 	// Test IP-based key and limit
-	key, limit := rl.getKeyAndLimit("192.168.1.1", "")
+	key, limits, _ := rl.getKeyAndLimits(ctx, "192.168.1.1", "")
 	assert.Equal(t, "ip:192.168.1.1", key)
-	assert.Equal(t, 10, limit)
+	assert.Equal(t, []Limit{PerSecond(10)}, limits)
 
 	// Test token-based key and limit with specific limit
-	key, limit = rl.getKeyAndLimit("192.168.1.1", "abc123")
+	key, limits, _ = rl.getKeyAndLimits(ctx, "192.168.1.1", "abc123")
 	assert.Equal(t, "token:abc123", key)
-	assert.Equal(t, 50, limit)
+	assert.Equal(t, []Limit{PerSecond(50)}, limits)
 
 	// Test token-based key and limit with default limit
-	key, limit = rl.getKeyAndLimit("192.168.1.1", "xyz789")
+	key, limits, _ = rl.getKeyAndLimits(ctx, "192.168.1.1", "xyz789")
+	assert.Equal(t, "token:xyz789", key)
+	assert.Equal(t, []Limit{PerSecond(100)}, limits)
+}
+
+func TestRateLimiter_GetKeyAndLimits_Namespace(t *testing.T) {
+	rl := New(nil, Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		Namespace:          "policy:write",
+	})
+	ctx := context.Background()
+
+	key, _, _ := rl.getKeyAndLimits(ctx, "192.168.1.1", "")
+	assert.Equal(t, "policy:write:ip:192.168.1.1", key)
+
+	key, _, _ = rl.getKeyAndLimits(ctx, "192.168.1.1", "abc123")
+	assert.Equal(t, "policy:write:token:abc123", key)
+}
+
+func TestRateLimiter_GetKeyAndLimits_IPPrefix(t *testing.T) {
+	rl := New(nil, Config{
+		DefaultIPLimits: []Limit{PerSecond(10)},
+		IPv4Prefix:      24,
+		IPv6Prefix:      64,
+	})
+	ctx := context.Background()
+
+	tests := map[string]string{
+		"192.168.1.1":             "ip:192.168.1.0/24",
+		"192.168.1.254":           "ip:192.168.1.0/24",
+		"::ffff:192.168.1.1":      "ip:192.168.1.0/24",
+		"2001:db8:cafe:1::1":      "ip:2001:db8:cafe:1::/64",
+		"2001:db8:cafe:1:ffff::9": "ip:2001:db8:cafe:1::/64",
+		"2001:db8:cafe:2::1":      "ip:2001:db8:cafe:2::/64",
+		"not-an-ip":               "ip:not-an-ip",
+	}
+
+	for ip, expected := range tests {
+		key, _, _ := rl.getKeyAndLimits(ctx, ip, "")
+		assert.Equal(t, expected, key, ip)
+	}
+
+	// Without prefixes the full address is kept
+	rl = New(nil, Config{DefaultIPLimits: []Limit{PerSecond(10)}})
+	key, _, _ := rl.getKeyAndLimits(ctx, "2001:db8:cafe:1::1", "")
+	assert.Equal(t, "ip:2001:db8:cafe:1::1", key)
+}
+
+func TestRateLimiter_GetKeyAndLimits_UnknownTokens(t *testing.T) {
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		TokenLimits:        map[string][]Limit{"abc123": {PerSecond(50)}, "xyz789": nil},
+	}
+	ctx := context.Background()
+
+	// Unknown tokens fall back to IP-based limiting
+	config.UnknownTokens = UnknownTokenIP
+	rl := New(nil, config)
+
+	key, limits, err := rl.getKeyAndLimits(ctx, "192.168.1.1", "random")
+	assert.NoError(t, err)
+	assert.Equal(t, "ip:192.168.1.1", key)
+	assert.Equal(t, []Limit{PerSecond(10)}, limits)
+
+	// Known tokens without limits of their own get the default token limits
+	key, limits, _ = rl.getKeyAndLimits(ctx, "192.168.1.1", "xyz789")
 	assert.Equal(t, "token:xyz789", key)
-	assert.Equal(t, 100, limit)
-} 
\ No newline at end of file
+	assert.Equal(t, []Limit{PerSecond(100)}, limits)
+
+	// Unknown tokens are rejected
+	config.UnknownTokens = UnknownTokenReject
+	rl = New(nil, config)
+
+	_, _, err = rl.getKeyAndLimits(ctx, "192.168.1.1", "random")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+
+	key, _, err = rl.getKeyAndLimits(ctx, "192.168.1.1", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, "token:abc123", key)
+}
+
+func TestRateLimiter_CheckLimit_ConfiguredWindow(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {PerHour(1000)}},
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// The counter belongs to the hour containing the current time
+	hourStart := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	counterKey := fmt.Sprintf("token:abc123:1h0m0s:%d", hourStart.UnixMilli())
+
+	// Mock expectations
+	mockStorage.On("Hit", ctx, "token:abc123", []Counter{{Key: counterKey, Window: time.Hour, Limit: 1000}}, 5*time.Minute).
+		Return(&HitResult{Counts: []int64{400}}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 600, result.Remaining)
+	assert.Equal(t, hourStart.Add(time.Hour), result.ResetTime)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_MultipleLimits(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10), PerDay(1000)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	dayKey := fmt.Sprintf("ip:192.168.1.1:24h0m0s:%d", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli())
+	counters := []Counter{
+		{Key: windowKey("ip:192.168.1.1"), Window: time.Second, Limit: 10},
+		{Key: dayKey, Window: 24 * time.Hour, Limit: 1000},
+	}
+
+	// Mock expectations
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", counters, 5*time.Minute).
+		Return(&HitResult{Counts: []int64{2, 995}}, nil).Once()
+
+	// The daily quota is the most restrictive limit
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 5, result.Remaining)
+	assert.Equal(t, PerDay(1000), result.Rule)
+	assert.Equal(t, time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), result.ResetTime)
+
+	// Exceeding the daily quota rejects even though the per-second limit is fine
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", counters, 5*time.Minute).
+		Return(&HitResult{Counts: []int64{3, 1001}, Blocked: true, BlockTTL: 5 * time.Minute}, nil).Once()
+
+	result, err = rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, PerDay(1000), result.Rule)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_MultipleLimitsWithoutBlocking(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		Algorithm:          GCRA,
+		DefaultIPLimits:    []Limit{PerSecond(10), PerMinute(100)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		TokenLimits:        make(map[string][]Limit),
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Mock expectations
+	mockStorage.On("ApplyGCRA", ctx, "ip:192.168.1.1", []Bucket{
+		{Key: "ip:192.168.1.1:1s", Burst: 10, Interval: 100 * time.Millisecond},
+		{Key: "ip:192.168.1.1:1m0s", Burst: 100, Interval: 600 * time.Millisecond},
+	}).Return([]GCRAState{
+		{Allowed: true, Remaining: 4, ResetAfter: 600 * time.Millisecond},
+		{Allowed: false, RetryAfter: 2 * time.Second, ResetAfter: time.Minute},
+	}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.False(t, result.Blocked)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, PerMinute(100), result.Rule)
+	assert.Equal(t, testNow.Add(2*time.Second), result.ResetTime)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_RejectedNotCharged(t *testing.T) {
+	for _, algorithm := range []Algorithm{FixedWindow, SlidingWindow, TokenBucket, GCRA} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			storage, clock := newTestMemoryStorage(t)
+			rl := New(storage, Config{
+				Algorithm:       algorithm,
+				DefaultIPLimits: []Limit{PerSecond(2), PerHour(10)},
+			})
+			rl.now = clock.Now
+			ctx := context.Background()
+
+			// A client retrying over its per-second limit...
+			allowed := 0
+			for i := 0; i < 20; i++ {
+				result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+				assert.NoError(t, err)
+				if result.Allowed {
+					allowed++
+				}
+			}
+			assert.Equal(t, 2, allowed)
+
+			// ...only spends its hourly quota on the requests that got through
+			clock.Advance(time.Second)
+			result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+}
+
+func TestRateLimiter_CheckLimit_DryRun(t *testing.T) {
+	for _, algorithm := range []Algorithm{FixedWindow, SlidingWindow} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			storage, clock := newTestMemoryStorage(t)
+			rl := New(storage, Config{
+				Algorithm:       algorithm,
+				DefaultIPLimits: []Limit{PerSecond(1)},
+				BlockDuration:   5 * time.Minute,
+			})
+			rl.now = clock.Now
+			ctx := context.Background()
+
+			// A dry run reports the excess without blocking the key...
+			for i, allowed := range []bool{true, false, false} {
+				result, err := rl.CheckLimit(DryRun(ctx), "192.168.1.1", "")
+				assert.NoError(t, err)
+				assert.Equal(t, allowed, result.Allowed, "request %d", i+1)
+				assert.False(t, result.Blocked)
+				assert.True(t, result.DryRun)
+			}
+
+			blocked, _ := storage.IsBlocked(ctx, "ip:192.168.1.1")
+			assert.False(t, blocked)
+
+			// ...so the enforced checks of the next window are not rejected
+			clock.Advance(time.Second)
+			result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+		})
+	}
+}
+
+func TestRateLimiter_CheckLimit_IPOnly(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		IdentityMode:       IdentityIP,
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// The token is ignored
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{3}}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 7, result.Remaining)
+	assert.Equal(t, "ip:192.168.1.1", result.Key)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_IPAndToken(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		IdentityMode:       IdentityIPAndToken,
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Both identities are counted; the one with fewer requests left is reported
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{3}}, nil)
+	mockStorage.On("Hit", ctx, "token:abc123", secondCounter("token:abc123", 100), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{98}}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, "token:abc123", result.Key)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_IPAndTokenBlockedIP(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		IdentityMode:       IdentityIPAndToken,
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// A blocked IP cannot get through with a token
+	mockStorage.On("Hit", ctx, "token:random", secondCounter("token:random", 100), 5*time.Minute).
+		Return(&HitResult{Counts: []int64{1}}, nil)
+	mockStorage.On("Hit", ctx, "ip:192.168.1.1", secondCounter("ip:192.168.1.1", 10), 5*time.Minute).
+		Return(&HitResult{Blocked: true, BlockTTL: time.Minute}, nil)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "random")
+
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "ip:192.168.1.1", result.Key)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_IPAndTokenBlockedToken(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	rl := New(storage, Config{
+		DefaultIPLimits:    []Limit{PerMinute(5)},
+		DefaultTokenLimits: []Limit{PerMinute(2)},
+		BlockDuration:      5 * time.Minute,
+		IdentityMode:       IdentityIPAndToken,
+	})
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	// A client hammering with a blocked token is rejected by the token alone...
+	for i := 0; i < 20; i++ {
+		result, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+		assert.NoError(t, err)
+		assert.Equal(t, i < 2, result.Allowed, "request %d", i+1)
+		if !result.Allowed {
+			assert.Equal(t, "token:abc123", result.Key)
+		}
+	}
+
+	// ...so its IP, which may be shared, is neither blocked nor used up
+	blocked, err := storage.IsBlocked(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+
+	result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func TestRateLimiter_CheckLimit_AccessList(t *testing.T) {
+	mockStorage := new(MockStorage)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(10)},
+		DefaultTokenLimits: []Limit{PerSecond(100)},
+		BlockDuration:      5 * time.Minute,
+		AccessList: NewAccessList(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			[]netip.Prefix{netip.MustParsePrefix("1.2.3.4/32")},
+		),
+	}
+
+	rl := New(mockStorage, config)
+	rl.now = fixedNow
+	ctx := context.Background()
+
+	// Neither decision touches the storage
+	result, err := rl.CheckLimit(ctx, "10.1.1.1", "abc123")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, ReasonAllowlisted, result.Reason)
+
+	result, err = rl.CheckLimit(ctx, "1.2.3.4", "")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, ReasonDenylisted, result.Reason)
+
+	mockStorage.AssertExpectations(t)
+}
+
+func TestRateLimiter_CheckLimit_BlockEscalation(t *testing.T) {
+	for _, algorithm := range []Algorithm{FixedWindow, SlidingWindow} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			storage, clock := newTestMemoryStorage(t)
+			config := Config{
+				Algorithm:       algorithm,
+				DefaultIPLimits: []Limit{PerSecond(1)},
+				BlockEscalation: []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute},
+				OffenceDecay:    time.Hour,
+			}
+
+			rl := New(storage, config)
+			rl.now = clock.Now
+			ctx := context.Background()
+
+			// offend exceeds the limit and returns how long the key was blocked for
+			offend := func() time.Duration {
+				clock.Advance(time.Second)
+				result, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+				assert.NoError(t, err)
+				assert.True(t, result.Allowed)
+
+				result, err = rl.CheckLimit(ctx, "192.168.1.1", "")
+				assert.NoError(t, err)
+				assert.True(t, result.Blocked)
+
+				ttl, _ := storage.BlockTTL(ctx, "ip:192.168.1.1")
+				assert.Equal(t, result.RetryAfter, ttl)
+				clock.Advance(ttl)
+				return ttl
+			}
+
+			assert.Equal(t, 10*time.Second, offend())
+			assert.Equal(t, time.Minute, offend())
+			assert.Equal(t, 5*time.Minute, offend())
+			assert.Equal(t, 5*time.Minute, offend())
+
+			// Offences older than the decay are forgiven
+			clock.Advance(time.Hour)
+			assert.Equal(t, 10*time.Second, offend())
+		})
+	}
+}
+
+func TestRateLimiter_Admin(t *testing.T) {
+	storage, clock := newTestMemoryStorage(t)
+	config := Config{
+		DefaultIPLimits:    []Limit{PerSecond(2), PerMinute(10)},
+		DefaultTokenLimits: []Limit{PerSecond(5)},
+		BlockDuration:      time.Minute,
+		TokenLimits:        map[string][]Limit{"abc123": {PerSecond(20)}},
+	}
+
+	rl := New(storage, config)
+	rl.now = clock.Now
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, err := rl.CheckLimit(ctx, "192.168.1.1", "")
+		assert.NoError(t, err)
+	}
+	_, err := rl.CheckLimit(ctx, "192.168.1.1", "abc123")
+	assert.NoError(t, err)
+
+	state, err := rl.Inspect(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.True(t, state.Blocked)
+	assert.Equal(t, time.Minute, state.BlockTTL)
+	// The rejected request was not counted
+	assert.Len(t, state.Windows, 2)
+	assert.Equal(t, int64(2), state.Windows[0].Count)
+	assert.Equal(t, PerSecond(2), state.Windows[0].Limit)
+	assert.Equal(t, int64(2), state.Windows[1].Count)
+
+	state, err = rl.Inspect(ctx, "token:abc123")
+	assert.NoError(t, err)
+	assert.False(t, state.Blocked)
+	assert.Equal(t, []WindowCount{{Limit: PerSecond(20), Count: 1, ResetTime: clock.Now().Truncate(time.Second).Add(time.Second)}}, state.Windows)
+
+	blocked, err := rl.BlockedKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []BlockedKey{{Key: "ip:192.168.1.1", TTL: time.Minute}}, blocked)
+
+	// Unblocking keeps the counters
+	assert.NoError(t, rl.Unblock(ctx, "ip:192.168.1.1"))
+	state, err = rl.Inspect(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.False(t, state.Blocked)
+	assert.Equal(t, int64(2), state.Windows[1].Count)
+
+	// Resetting clears them
+	assert.NoError(t, rl.Reset(ctx, "ip:192.168.1.1"))
+	state, err = rl.Inspect(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), state.Windows[1].Count)
+
+	blocked, err = rl.BlockedKeys(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, blocked)
+}
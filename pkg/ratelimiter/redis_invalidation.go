@@ -0,0 +1,25 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// invalidationsChannel announces the keys whose cached block state is stale
+const invalidationsChannel = "blocked:invalidations"
+
+// PublishInvalidation announces key on the invalidations channel
+func (r *RedisStorage) PublishInvalidation(ctx context.Context, key string) error {
+	if err := r.client.Publish(ctx, invalidationsChannel, key).Err(); err != nil {
+		return fmt.Errorf("failed to publish invalidation: %w", err)
+	}
+
+	return nil
+}
+
+// WatchInvalidations subscribes to the invalidations channel until ctx is
+// done. Every (re)subscription invalidates every key, since invalidations
+// published while disconnected are lost.
+func (r *RedisStorage) WatchInvalidations(ctx context.Context, invalidated func(key string)) error {
+	return r.subscribe(ctx, invalidationsChannel, invalidated)
+}
@@ -0,0 +1,210 @@
+package ratelimiter
+
+import "github.com/go-redis/redis/v8"
+
+// Lua scripts run atomically on the Redis server.
+// Timestamps are taken from the server clock (TIME) so that every
+// instance sharing the same Redis agrees on the current time.
+// Scripts are invoked with EVALSHA, falling back to EVAL (which also
+// caches the script) when the server does not know them yet.
+
+// hitScript checks the block key and, when it is not set, reads every
+// counter and either increments all of them or, when any would exceed its
+// limit, sets the block without counting the request
+//
+// KEYS[1]    - block key
+// KEYS[2..n] - fixed window counters
+// ARGV[1]    - block duration in milliseconds
+// ARGV[2i-2] - window of the counter at KEYS[i] in milliseconds
+// ARGV[2i-1] - limit of the counter at KEYS[i]
+//
+// Returns {blocked, block ttl in milliseconds, count of each counter including this hit...}
+var hitScript = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl ~= -2 then
+	return {1, ttl}
+end
+
+local result = {0, 0}
+local exceeded = false
+
+for i = 2, #KEYS do
+	local count = (tonumber(redis.call('GET', KEYS[i])) or 0) + 1
+	if count > tonumber(ARGV[2 * i - 1]) then
+		exceeded = true
+	end
+	result[#result + 1] = count
+end
+
+if not exceeded then
+	for i = 2, #KEYS do
+		if redis.call('INCR', KEYS[i]) == 1 then
+			redis.call('PEXPIRE', KEYS[i], ARGV[2 * i - 2])
+		end
+	end
+end
+
+local block = tonumber(ARGV[1])
+if exceeded and block > 0 then
+	redis.call('SET', KEYS[1], '1', 'PX', block)
+	result[1] = 1
+	result[2] = block
+end
+
+return result
+`)
+
+// slidingWindowScript prunes the entries older than their window from every
+// log and records the current request in all of them when each holds fewer
+// than its limit requests
+//
+// KEYS[1..n] - sliding window logs (sorted sets scored by microseconds)
+// ARGV[1]    - unique member identifying this request
+// ARGV[2i]   - window of the log at KEYS[i] in microseconds
+// ARGV[2i+1] - limit of the log at KEYS[i]
+//
+// Returns the number of requests in each log counting the current one
+var slidingWindowScript = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local counts = {}
+local allowed = true
+
+for i = 1, #KEYS do
+	redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', now - tonumber(ARGV[2 * i]))
+	counts[i] = redis.call('ZCARD', KEYS[i]) + 1
+	if counts[i] > tonumber(ARGV[2 * i + 1]) then
+		allowed = false
+	end
+end
+
+if allowed then
+	for i = 1, #KEYS do
+		redis.call('ZADD', KEYS[i], now, ARGV[1])
+		redis.call('PEXPIRE', KEYS[i], math.ceil(tonumber(ARGV[2 * i]) / 1000))
+	end
+end
+
+return counts
+`)
+
+// tokenBucketScript refills every bucket for the time elapsed since its last
+// update and takes one token from all of them when each holds one
+//
+// KEYS[1..n] - bucket hashes holding the fractional token count and last update
+// ARGV[2i-1] - capacity of the bucket at KEYS[i]
+// ARGV[2i]   - time for the bucket at KEYS[i] to gain one token in microseconds
+//
+// Returns {had a token, whole tokens left, microseconds until the next token} per bucket
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tokens = {}
+local allowed = true
+
+for i = 1, #KEYS do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local refill = tonumber(ARGV[2 * i])
+
+	local bucket = redis.call('HMGET', KEYS[i], 'tokens', 'ts')
+	local current = tonumber(bucket[1])
+	local ts = tonumber(bucket[2])
+	if current == nil or ts == nil then
+		current = capacity
+		ts = now
+	end
+
+	tokens[i] = math.min(capacity, current + math.max(0, now - ts) / refill)
+	if tokens[i] < 1 then
+		allowed = false
+	end
+end
+
+local result = {}
+
+for i = 1, #KEYS do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local refill = tonumber(ARGV[2 * i])
+
+	local had_token = 0
+	if tokens[i] >= 1 then
+		had_token = 1
+	end
+	if allowed then
+		tokens[i] = tokens[i] - 1
+	end
+
+	local next_token = 0
+	if tokens[i] < capacity then
+		next_token = math.ceil((1 - (tokens[i] - math.floor(tokens[i]))) * refill)
+	end
+
+	redis.call('HSET', KEYS[i], 'tokens', tokens[i], 'ts', now)
+	redis.call('PEXPIRE', KEYS[i], math.max(1, math.ceil((capacity - tokens[i]) * refill / 1000)))
+
+	result[#result + 1] = had_token
+	result[#result + 1] = math.floor(tokens[i])
+	result[#result + 1] = next_token
+end
+
+return result
+`)
+
+// gcraScript implements the generic cell rate algorithm on keys holding the
+// theoretical arrival time (TAT) of the next request, updating all of them
+// when the request conforms to every one
+//
+// KEYS[1..n] - theoretical arrival times in microseconds
+// ARGV[2i-1] - burst size of KEYS[i]
+// ARGV[2i]   - emission interval of KEYS[i] in microseconds
+//
+// Returns {allowed, remaining, retry after, reset after} per key, durations in microseconds
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local result = {}
+local new_tats = {}
+local allowed = true
+
+for i = 1, #KEYS do
+	local burst = tonumber(ARGV[2 * i - 1])
+	local emission = tonumber(ARGV[2 * i])
+
+	local tat = tonumber(redis.call('GET', KEYS[i]))
+	if tat == nil or tat < now then
+		tat = now
+	end
+
+	new_tats[i] = tat + emission
+	local allow_at = new_tats[i] - emission * burst
+	local diff = now - allow_at
+	local remaining = math.floor(diff / emission)
+
+	if remaining < 0 then
+		allowed = false
+		result[#result + 1] = 0
+		result[#result + 1] = 0
+		result[#result + 1] = math.ceil(-diff)
+		result[#result + 1] = math.ceil(tat - now)
+	else
+		result[#result + 1] = 1
+		result[#result + 1] = remaining
+		result[#result + 1] = 0
+		result[#result + 1] = math.ceil(new_tats[i] - now)
+	end
+end
+
+if allowed then
+	for i = 1, #KEYS do
+		redis.call('SET', KEYS[i], new_tats[i], 'PX', math.max(1, math.ceil((new_tats[i] - now) / 1000)))
+	end
+end
+
+return result
+`)
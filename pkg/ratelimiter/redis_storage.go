@@ -2,26 +2,147 @@ package ratelimiter
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-// RedisStorage implements Storage interface using Redis
+// RedisStorage implements Storage interface using Redis.
+// It works with a single node, Redis Sentinel and Redis Cluster: the keys
+// touched by one script share a hash tag, so they live in the same slot.
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
+}
+
+// RedisMode selects how RedisStorage connects to Redis
+type RedisMode string
+
+const (
+	// RedisStandalone connects to a single Redis node. It is the default.
+	RedisStandalone RedisMode = "standalone"
+
+	// RedisSentinel connects to the master named MasterName through the
+	// Sentinels at Addrs
+	RedisSentinel RedisMode = "sentinel"
+
+	// RedisCluster connects to a Redis Cluster through the seed nodes at Addrs
+	RedisCluster RedisMode = "cluster"
+)
+
+// ErrUnknownRedisMode is returned when a Redis mode name is not recognised
+var ErrUnknownRedisMode = errors.New("unknown Redis mode")
+
+// ParseRedisMode converts a mode name into a RedisMode.
+// An empty name selects RedisStandalone.
+func ParseRedisMode(name string) (RedisMode, error) {
+	switch RedisMode(name) {
+	case "", RedisStandalone:
+		return RedisStandalone, nil
+	case RedisSentinel:
+		return RedisSentinel, nil
+	case RedisCluster:
+		return RedisCluster, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownRedisMode, name)
+	}
+}
+
+// RedisOptions configures the connection of NewRedisStorageWithOptions.
+// Zero timeouts and pool size keep the go-redis defaults.
+type RedisOptions struct {
+	Mode  RedisMode
+	Addrs []string
+
+	// MasterName is the name of the master monitored by the Sentinels
+	MasterName string
+
+	Username string
+	Password string
+
+	// SentinelUsername and SentinelPassword authenticate with the Sentinels
+	// when they differ from the master credentials
+	SentinelUsername string
+	SentinelPassword string
+
+	// DB is ignored by Redis Cluster, which only has database 0
+	DB int
+
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+
+	PoolSize     int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
 // NewRedisStorage creates a new Redis storage instance
 func NewRedisStorage(host, port, password string, db int) (*RedisStorage, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", host, port),
+	return NewRedisStorageWithOptions(RedisOptions{
+		Addrs:    []string{fmt.Sprintf("%s:%s", host, port)},
 		Password: password,
 		DB:       db,
 	})
+}
+
+// NewRedisStorageWithOptions creates a Redis storage connected to a single
+// node, a master monitored by Sentinels or a cluster
+func NewRedisStorageWithOptions(opts RedisOptions) (*RedisStorage, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("failed to connect to Redis: no address given")
+	}
 
+	universal := &redis.UniversalOptions{
+		Addrs:            opts.Addrs,
+		MasterName:       opts.MasterName,
+		Username:         opts.Username,
+		Password:         opts.Password,
+		SentinelUsername: opts.SentinelUsername,
+		SentinelPassword: opts.SentinelPassword,
+		DB:               opts.DB,
+		TLSConfig:        opts.TLSConfig,
+		PoolSize:         opts.PoolSize,
+		DialTimeout:      opts.DialTimeout,
+		ReadTimeout:      opts.ReadTimeout,
+		WriteTimeout:     opts.WriteTimeout,
+	}
+
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case "", RedisStandalone:
+		client = redis.NewClient(universal.Simple())
+	case RedisSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("failed to connect to Redis: Sentinel requires a master name")
+		}
+		client = redis.NewFailoverClient(universal.Failover())
+	case RedisCluster:
+		client = redis.NewClusterClient(universal.Cluster())
+	default:
+		return nil, fmt.Errorf("failed to connect to Redis: %w: %q", ErrUnknownRedisMode, opts.Mode)
+	}
+
+	storage, err := NewRedisStorageFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// NewRedisStorageFromClient creates a Redis storage using client, which may
+// be a single node, failover or cluster client. Closing the storage closes
+// the client.
+func NewRedisStorageFromClient(client redis.UniversalClient) (*RedisStorage, error) {
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -33,11 +154,41 @@ func NewRedisStorage(host, port, password string, db int) (*RedisStorage, error)
 	return &RedisStorage{client: client}, nil
 }
 
+// blockKey returns the Redis key of the block of key
+func blockKey(key string) string {
+	return fmt.Sprintf("blocked:{%s}", key)
+}
+
+// counterKey returns the Redis key of a fixed window counter. Counter keys
+// are "<key>:<window>:<start>", and <key> is their hash tag so that they
+// share a cluster slot with the block key of <key> in Hit.
+func counterKey(key string) string {
+	if i := strings.LastIndex(key, ":"); i > 0 {
+		if j := strings.LastIndex(key[:i], ":"); j > 0 {
+			return fmt.Sprintf("rate_limit:{%s}%s", key[:j], key[j:])
+		}
+	}
+	return fmt.Sprintf("rate_limit:{%s}", key)
+}
+
+// limitKey returns the Redis key of the sliding window log, token bucket or
+// arrival time of a limit. Limit keys are "<key>:<window>" (or
+// "<key>:offences"), and <key> is their hash tag so that the keys of one
+// script share a cluster slot, and so that they are told apart from the keys
+// of identities key is a prefix of, such as IPv6 addresses.
+func limitKey(family, key, entryKey string) (string, error) {
+	suffix, found := strings.CutPrefix(entryKey, key+":")
+	if !found {
+		return "", fmt.Errorf("%s is not a limit key of %s", entryKey, key)
+	}
+	return fmt.Sprintf("%s:{%s}:%s", family, key, suffix), nil
+}
+
 // Increment increments the request count for the given key
 func (r *RedisStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
 	pipe := r.client.TxPipeline()
 	
-	countKey := fmt.Sprintf("rate_limit:%s", key)
+	countKey := counterKey(key)
 	
 	incr := pipe.Incr(ctx, countKey)
 	pipe.Expire(ctx, countKey, window)
@@ -52,7 +203,7 @@ func (r *RedisStorage) Increment(ctx context.Context, key string, window time.Du
 
 // Get retrieves the current count for the given key
 func (r *RedisStorage) Get(ctx context.Context, key string) (int64, error) {
-	countKey := fmt.Sprintf("rate_limit:%s", key)
+	countKey := counterKey(key)
 	
 	val, err := r.client.Get(ctx, countKey).Result()
 	if err != nil {
@@ -72,9 +223,7 @@ func (r *RedisStorage) Get(ctx context.Context, key string) (int64, error) {
 
 // SetBlock sets a block for the given key with the specified duration
 func (r *RedisStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
-	blockKey := fmt.Sprintf("blocked:%s", key)
-	
-	err := r.client.Set(ctx, blockKey, "1", duration).Err()
+	err := r.client.Set(ctx, blockKey(key), "1", duration).Err()
 	if err != nil {
 		return fmt.Errorf("failed to set block: %w", err)
 	}
@@ -84,9 +233,7 @@ func (r *RedisStorage) SetBlock(ctx context.Context, key string, duration time.D
 
 // IsBlocked checks if the given key is currently blocked
 func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
-	blockKey := fmt.Sprintf("blocked:%s", key)
-	
-	exists, err := r.client.Exists(ctx, blockKey).Result()
+	exists, err := r.client.Exists(ctx, blockKey(key)).Result()
 	if err != nil {
 		return false, fmt.Errorf("failed to check block status: %w", err)
 	}
@@ -94,6 +241,325 @@ func (r *RedisStorage) IsBlocked(ctx context.Context, key string) (bool, error)
 	return exists > 0, nil
 }
 
+// BlockTTL returns the remaining TTL of the block of the given key
+func (r *RedisStorage) BlockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, blockKey(key)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block TTL: %w", err)
+	}
+	
+	// go-redis reports a missing key as -2 and a key without expiry as -1,
+	// unscaled by the millisecond unit of PTTL
+	switch ttl {
+	case -2:
+		return 0, nil
+	case -1:
+		return -1, nil
+	}
+	
+	return ttl, nil
+}
+
+// Hit atomically checks the block and the counters, then either increments
+// the counters or sets the block, in a single script.
+// The counters must be fixed window counters of key, which share its hash tag.
+func (r *RedisStorage) Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error) {
+	keys := make([]string, 0, len(counters)+1)
+	args := make([]interface{}, 0, 2*len(counters)+1)
+	
+	keys = append(keys, blockKey(key))
+	args = append(args, blockDuration.Milliseconds())
+	
+	for _, counter := range counters {
+		countKey := counterKey(counter.Key)
+		if !strings.HasPrefix(countKey, fmt.Sprintf("rate_limit:{%s}:", key)) {
+			return nil, fmt.Errorf("failed to hit rate limit: %s is not a counter of %s", counter.Key, key)
+		}
+		keys = append(keys, countKey)
+		args = append(args, counter.Window.Milliseconds(), counter.Limit)
+	}
+	
+	values, err := hitScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hit rate limit: %w", err)
+	}
+	
+	result := &HitResult{
+		Counts:  values[2:],
+		Blocked: values[0] == 1,
+	}
+	switch {
+	case values[1] == -1:
+		// A block without expiry
+		result.BlockTTL = -1
+	case values[1] > 0:
+		result.BlockTTL = time.Duration(values[1]) * time.Millisecond
+	}
+	
+	return result, nil
+}
+
+// AddRequest records a request in the sliding window log of every limit
+// when each of them has room for it, in a single script
+func (r *RedisStorage) AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error) {
+	keys := make([]string, len(logs))
+	args := make([]interface{}, 0, 2*len(logs)+1)
+	
+	args = append(args, fmt.Sprintf("%d-%x", time.Now().UnixNano(), rand.Uint64()))
+	
+	for i, log := range logs {
+		logKey, err := limitKey("sliding_window", key, log.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add request to sliding window: %w", err)
+		}
+		keys[i] = logKey
+		args = append(args, log.Window.Microseconds(), log.Limit)
+	}
+	
+	counts, err := slidingWindowScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to add request to sliding window: %w", err)
+	}
+	
+	return counts, nil
+}
+
+// TakeToken takes one token from the bucket of every limit when each of them
+// holds one, in a single script
+func (r *RedisStorage) TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error) {
+	keys, args, err := bucketArgs("token_bucket", key, buckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token: %w", err)
+	}
+	
+	values, err := tokenBucketScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take token: %w", err)
+	}
+	
+	states := make([]TokenBucketState, len(buckets))
+	for i := range states {
+		states[i] = TokenBucketState{
+			Allowed:   values[3*i] == 1,
+			Tokens:    values[3*i+1],
+			NextToken: time.Duration(values[3*i+2]) * time.Microsecond,
+		}
+	}
+	
+	return states, nil
+}
+
+// ApplyGCRA checks a request against the theoretical arrival time of every
+// limit and updates them when the request conforms to all, in a single script
+func (r *RedisStorage) ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error) {
+	keys, args, err := bucketArgs("gcra", key, buckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply GCRA: %w", err)
+	}
+	
+	values, err := gcraScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply GCRA: %w", err)
+	}
+	
+	states := make([]GCRAState, len(buckets))
+	for i := range states {
+		states[i] = GCRAState{
+			Allowed:    values[4*i] == 1,
+			Remaining:  values[4*i+1],
+			RetryAfter: time.Duration(values[4*i+2]) * time.Microsecond,
+			ResetAfter: time.Duration(values[4*i+3]) * time.Microsecond,
+		}
+	}
+	
+	return states, nil
+}
+
+// bucketArgs returns the keys and arguments of the token bucket and GCRA
+// scripts: the Redis key of each bucket, and its burst and interval in
+// microseconds
+func bucketArgs(family, key string, buckets []Bucket) ([]string, []interface{}, error) {
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 2*len(buckets))
+	
+	for i, bucket := range buckets {
+		bucketKey, err := limitKey(family, key, bucket.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[i] = bucketKey
+		args = append(args, bucket.Burst, bucket.Interval.Microseconds())
+	}
+	
+	return keys, args, nil
+}
+
+// Unblock deletes the block key of the given key
+func (r *RedisStorage) Unblock(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, blockKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to unblock: %w", err)
+	}
+	
+	return nil
+}
+
+// Reset deletes the block key and every key of each algorithm stored for the
+// given key, scanning for the per-limit keys. They all have key as their
+// hash tag, so the keys of other identities sharing its prefix are kept.
+func (r *RedisStorage) Reset(ctx context.Context, key string) error {
+	keys := []string{blockKey(key)}
+	patterns := []string{}
+	
+	for _, family := range []string{"rate_limit", "sliding_window", "token_bucket", "gcra"} {
+		keys = append(keys, fmt.Sprintf("%s:{%s}", family, key))
+		patterns = append(patterns, fmt.Sprintf("%s:{%s}:*", family, escapePattern(key)))
+	}
+	
+	for _, pattern := range patterns {
+		matched, err := r.scan(ctx, pattern)
+		if err != nil {
+			return fmt.Errorf("failed to reset: %w", err)
+		}
+		keys = append(keys, matched...)
+	}
+	
+	// One DEL per key, as a cluster refuses a DEL across slots
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to reset: %w", err)
+	}
+	
+	return nil
+}
+
+// BlockedKeys scans the block keys and reads their TTLs in one pipeline
+func (r *RedisStorage) BlockedKeys(ctx context.Context) ([]BlockedKey, error) {
+	keys, err := r.scan(ctx, "blocked:{*}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocked keys: %w", err)
+	}
+	
+	pipe := r.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to list blocked keys: %w", err)
+	}
+	
+	blocked := make([]BlockedKey, 0, len(keys))
+	for i, key := range keys {
+		ttl := ttls[i].Val()
+		
+		// The key expired between the scan and the pipeline
+		if ttl == -2 {
+			continue
+		}
+		if ttl < 0 {
+			ttl = -1
+		}
+		
+		blocked = append(blocked, BlockedKey{Key: strings.TrimSuffix(strings.TrimPrefix(key, "blocked:{"), "}"), TTL: ttl})
+	}
+	
+	// Sorted by key rather than by block key, where "}" sorts after the
+	// characters of longer keys
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].Key < blocked[j].Key })
+	
+	return blocked, nil
+}
+
+// scan returns every key matching pattern using SCAN, without blocking the
+// server the way KEYS would. A cluster is scanned master by master.
+func (r *RedisStorage) scan(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, r.client, pattern)
+	}
+	
+	var mu sync.Mutex
+	var keys []string
+	
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		matched, err := scanNode(ctx, node, pattern)
+		if err != nil {
+			return err
+		}
+		
+		mu.Lock()
+		keys = append(keys, matched...)
+		mu.Unlock()
+		return nil
+	})
+	
+	return keys, err
+}
+
+// scanNode returns every key of a single node matching pattern
+func scanNode(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	
+	iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	
+	return keys, iter.Err()
+}
+
+// subscribe calls received with the payload of every message published on
+// channel until ctx is done, and with an empty payload on every
+// (re)subscription
+func (r *RedisStorage) subscribe(ctx context.Context, channel string, received func(payload string)) error {
+	pubsub := r.client.Subscribe(ctx, channel)
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// The next Receive reconnects
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+	
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				received("")
+			}
+		case *redis.Message:
+			received(msg.Payload)
+		}
+	}
+}
+
+// escapePattern escapes the glob characters of a key for use in a SCAN pattern
+func escapePattern(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
 // Close closes the Redis connection
 func (r *RedisStorage) Close() error {
 	return r.client.Close()
@@ -0,0 +1,190 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRedisStorage starts an embedded Redis and connects a storage to
+// it. advance moves both the expiry of its keys and its TIME clock, which
+// the scripts read.
+func newTestRedisStorage(t *testing.T) (*RedisStorage, *miniredis.Miniredis, func(time.Duration)) {
+	server := miniredis.RunT(t)
+	clock := newFakeClock()
+	server.SetTime(clock.Now())
+
+	advance := func(d time.Duration) {
+		clock.Advance(d)
+		server.SetTime(clock.Now())
+		server.FastForward(d)
+	}
+
+	return connectTestRedisStorage(t, server), server, advance
+}
+
+// connectTestRedisStorage connects another storage to server, as another
+// instance sharing the same Redis would
+func connectTestRedisStorage(t *testing.T, server *miniredis.Miniredis) *RedisStorage {
+	storage, err := NewRedisStorageWithOptions(RedisOptions{Addrs: []string{server.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestRedisStorage_KeysShareHashTag(t *testing.T) {
+	assert.Equal(t, "blocked:{ip:192.168.1.1}", blockKey("ip:192.168.1.1"))
+	assert.Equal(t, "rate_limit:{ip:192.168.1.1}:1s:1735732800000", counterKey("ip:192.168.1.1:1s:1735732800000"))
+
+	// Keys that contain colons themselves keep their whole identity as the tag
+	assert.Equal(t, "rate_limit:{policy:write:ip:2001:db8::/64}:1m0s:0", counterKey("policy:write:ip:2001:db8::/64:1m0s:0"))
+	assert.Equal(t, "rate_limit:{token:abc}", counterKey("token:abc"))
+
+	logKey, err := limitKey("sliding_window", "ip:2001:db8::1", "ip:2001:db8::1:1s")
+	assert.NoError(t, err)
+	assert.Equal(t, "sliding_window:{ip:2001:db8::1}:1s", logKey)
+
+	_, err = limitKey("gcra", "ip:2001:db8::1", "ip:2001:db8::2:1s")
+	assert.Error(t, err)
+}
+
+func TestParseRedisMode(t *testing.T) {
+	for name, expected := range map[string]RedisMode{"": RedisStandalone, "standalone": RedisStandalone, "sentinel": RedisSentinel, "cluster": RedisCluster} {
+		mode, err := ParseRedisMode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := ParseRedisMode("replica")
+	assert.ErrorIs(t, err, ErrUnknownRedisMode)
+}
+
+func TestRedisStorage_BlockWithoutExpiry(t *testing.T) {
+	storage, server, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	server.Set("blocked:{ip:192.168.1.1}", "1")
+
+	ttl, err := storage.BlockTTL(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	result, err := storage.Hit(ctx, "ip:192.168.1.1", []Counter{{Key: "ip:192.168.1.1:1s:0", Window: time.Second, Limit: 10}}, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, result.Blocked)
+	assert.Equal(t, time.Duration(-1), result.BlockTTL)
+
+	blocked, err := storage.BlockedKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []BlockedKey{{Key: "ip:192.168.1.1", TTL: -1}}, blocked)
+}
+
+func TestRedisStorage_ResetEscapesPattern(t *testing.T) {
+	storage, server, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"token:a*", "token:ab"} {
+		useEveryAlgorithm(ctx, storage, key)
+	}
+
+	// The glob characters of the key are matched literally
+	assert.NoError(t, storage.Reset(ctx, "token:a*"))
+	assert.Equal(t, []string{
+		"gcra:{token:ab}:1s",
+		"rate_limit:{token:ab}:1s:0",
+		"sliding_window:{token:ab}:1s",
+		"token_bucket:{token:ab}:1s",
+	}, server.Keys())
+}
+
+func TestRedisStorage_TokenStore(t *testing.T) {
+	storage, _, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	assert.NoError(t, storage.SetToken(ctx, "partner", []Limit{PerSecond(10), PerHour(1000)}))
+	assert.NoError(t, storage.SetToken(ctx, "basic", nil))
+
+	limits, found, err := storage.Token(ctx, "partner")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []Limit{PerSecond(10), PerHour(1000)}, limits)
+
+	_, found, err = storage.Token(ctx, "unknown")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	tokens, err := storage.Tokens(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]Limit{"partner": {PerSecond(10), PerHour(1000)}, "basic": nil}, tokens)
+
+	deleted, err := storage.DeleteToken(ctx, "partner")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	deleted, _ = storage.DeleteToken(ctx, "partner")
+	assert.False(t, deleted)
+}
+
+func TestRedisStorage_TokenPropagation(t *testing.T) {
+	_, server, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	replica1, err := NewManagedTokenRegistry(ctx, connectTestRedisStorage(t, server), nil)
+	assert.NoError(t, err)
+	defer replica1.Close()
+
+	replica2, err := NewManagedTokenRegistry(ctx, connectTestRedisStorage(t, server), nil)
+	assert.NoError(t, err)
+	defer replica2.Close()
+
+	// Wait for both watchers to subscribe before changing tokens
+	assert.Eventually(t, func() bool {
+		return server.PubSubNumSub(tokensChannel)[tokensChannel] == 2
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(t, replica1.SetToken(ctx, "new", []Limit{PerSecond(3)}))
+	assert.Eventually(t, func() bool {
+		limits, known, _ := replica2.Lookup(ctx, "new")
+		return known && limits[0] == PerSecond(3)
+	}, time.Second, time.Millisecond)
+
+	_, err = replica2.RevokeToken(ctx, "new")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		_, known, _ := replica1.Lookup(ctx, "new")
+		return !known
+	}, time.Second, time.Millisecond)
+}
+
+func TestRedisStorage_Invalidations(t *testing.T) {
+	_, server, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	first := connectTestRedisStorage(t, server)
+	second := connectTestRedisStorage(t, server)
+	firstCache := NewNearCacheStorage(first, first)
+	secondCache := NewNearCacheStorage(second, second)
+	defer firstCache.Close()
+	defer secondCache.Close()
+
+	assert.Eventually(t, func() bool {
+		return server.PubSubNumSub(invalidationsChannel)[invalidationsChannel] == 2
+	}, time.Second, time.Millisecond)
+
+	// Both instances cache the block, then one of them unblocks the key
+	assert.NoError(t, firstCache.SetBlock(ctx, "ip:192.168.1.1", time.Minute))
+	blocked, err := secondCache.IsBlocked(ctx, "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	assert.NoError(t, firstCache.Unblock(ctx, "ip:192.168.1.1"))
+	assert.Eventually(t, func() bool {
+		blocked, _ := secondCache.IsBlocked(ctx, "ip:192.168.1.1")
+		return !blocked
+	}, time.Second, time.Millisecond)
+}
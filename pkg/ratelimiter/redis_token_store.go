@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// tokensKey is the hash holding the managed tokens and their limits
+	tokensKey = "token_registry"
+
+	// tokensChannel announces the token of every change to tokensKey
+	tokensChannel = "token_registry:changes"
+)
+
+// Tokens returns every token of the token registry hash
+func (r *RedisStorage) Tokens(ctx context.Context) (map[string][]Limit, error) {
+	values, err := r.client.HGetAll(ctx, tokensKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tokens: %w", err)
+	}
+
+	tokens := make(map[string][]Limit, len(values))
+	for token, value := range values {
+		limits, err := decodeTokenLimits(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load token %q: %w", token, err)
+		}
+		tokens[token] = limits
+	}
+
+	return tokens, nil
+}
+
+// Token returns the limits of token from the token registry hash
+func (r *RedisStorage) Token(ctx context.Context, token string) ([]Limit, bool, error) {
+	value, err := r.client.HGet(ctx, tokensKey, token).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load token: %w", err)
+	}
+
+	limits, err := decodeTokenLimits(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load token %q: %w", token, err)
+	}
+
+	return limits, true, nil
+}
+
+// SetToken stores token and publishes the change
+func (r *RedisStorage) SetToken(ctx context.Context, token string, limits []Limit) error {
+	value, err := encodeTokenLimits(limits)
+	if err != nil {
+		return fmt.Errorf("failed to set token: %w", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, tokensKey, token, value)
+	pipe.Publish(ctx, tokensChannel, token)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to set token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteToken deletes token and publishes the change
+func (r *RedisStorage) DeleteToken(ctx context.Context, token string) (bool, error) {
+	pipe := r.client.TxPipeline()
+	deleted := pipe.HDel(ctx, tokensKey, token)
+	pipe.Publish(ctx, tokensChannel, token)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete token: %w", err)
+	}
+
+	return deleted.Val() > 0, nil
+}
+
+// WatchTokens subscribes to the token changes until ctx is done. Every
+// (re)subscription asks for a full reload, since changes published while
+// disconnected are lost.
+func (r *RedisStorage) WatchTokens(ctx context.Context, changed func(token string)) error {
+	return r.subscribe(ctx, tokensChannel, changed)
+}
@@ -20,6 +20,118 @@ type Storage interface {
 	// IsBlocked checks if the given key is currently blocked
 	IsBlocked(ctx context.Context, key string) (bool, error)
 	
+	// BlockTTL returns how long the given key remains blocked, or zero if it is not blocked
+	// A block without expiry is reported as a negative duration
+	BlockTTL(ctx context.Context, key string) (time.Duration, error)
+	
+	// Hit atomically checks whether key is blocked and, if not, increments
+	// every counter when none of them would exceed its limit, or blocks key for
+	// blockDuration when any would
+	// A rejected request is not counted, so it never uses up the other limits
+	// Counters are incremented the same way as Increment
+	Hit(ctx context.Context, key string, counters []Counter, blockDuration time.Duration) (*HitResult, error)
+	
+	// AddRequest atomically records a request in the sliding window log of
+	// every limit of the given key, only when each log holds fewer than its
+	// limit requests within its window, so rejected requests never take up room in the logs
+	// Returns the count of each log in order, counting this request whether or not it was recorded
+	AddRequest(ctx context.Context, key string, logs []Counter) ([]int64, error)
+	
+	// TakeToken atomically takes one token from the bucket of every limit of
+	// the given key, only when each of them holds one
+	// Each bucket holds up to Burst tokens and gains one token every Interval
+	TakeToken(ctx context.Context, key string, buckets []Bucket) ([]TokenBucketState, error)
+	
+	// ApplyGCRA atomically checks a request against the theoretical arrival
+	// time of every limit of the given key, updating them only when the request conforms to all
+	// Requests are spaced by Interval with up to Burst requests allowed at once
+	ApplyGCRA(ctx context.Context, key string, buckets []Bucket) ([]GCRAState, error)
+	
+	// Unblock removes the block of the given key
+	Unblock(ctx context.Context, key string) error
+	
+	// Reset removes the block and every counter, log, bucket and arrival time
+	// stored for the given identity key, including the ones of its limits
+	Reset(ctx context.Context, key string) error
+	
+	// BlockedKeys lists the keys that are currently blocked, sorted by key
+	BlockedKeys(ctx context.Context) ([]BlockedKey, error)
+	
 	// Close closes the storage connection
 	Close() error
-} 
\ No newline at end of file
+}
+
+// BlockedKey is a blocked key with the remaining duration of its block
+type BlockedKey struct {
+	Key string
+	
+	// TTL is negative for a block without expiry
+	TTL time.Duration
+}
+
+// Counter identifies the fixed window counter checked by Hit or the sliding
+// window log checked by AddRequest of one limit. Its key starts with the
+// identity key and a colon.
+type Counter struct {
+	Key    string
+	Window time.Duration
+	Limit  int64
+}
+
+// Bucket identifies the token bucket or GCRA arrival time of one limit.
+// Its key starts with the identity key and a colon.
+type Bucket struct {
+	Key string
+	
+	// Burst is the capacity of the token bucket or the GCRA burst size
+	Burst int64
+	
+	// Interval is how often the token bucket gains a token, or how far apart GCRA spaces requests
+	Interval time.Duration
+}
+
+// HitResult represents the outcome of Hit
+type HitResult struct {
+	// Counts holds the count of each counter including this hit, in order
+	// When a counter would exceed its limit nothing is incremented and Counts
+	// holds the counts the hit would have reached
+	// It is empty when the key was already blocked and nothing was counted
+	Counts []int64
+	
+	// Blocked reports whether the key is blocked, either from before or as a result of this hit
+	Blocked bool
+	
+	// BlockTTL is the remaining duration of the block, negative for a block without expiry
+	BlockTTL time.Duration
+}
+
+// TokenBucketState represents the state of a token bucket after a take
+type TokenBucketState struct {
+	// Allowed reports whether the bucket held a token. Tokens are only taken
+	// when every bucket of the request held one.
+	Allowed bool
+	
+	// Tokens is the number of whole tokens left in the bucket
+	Tokens int64
+	
+	// NextToken is the time until the bucket gains its next token
+	// It is zero when the bucket is full
+	NextToken time.Duration
+}
+
+// GCRAState represents the outcome of a GCRA check
+type GCRAState struct {
+	// Allowed reports whether the request conforms to this arrival time.
+	// Arrival times are only updated when the request conforms to all of them.
+	Allowed bool
+	
+	// Remaining is the number of requests that would still be allowed right now
+	Remaining int64
+	
+	// RetryAfter is the time until a rejected request would be allowed
+	// It is zero when the request is allowed
+	RetryAfter time.Duration
+	
+	// ResetAfter is the time until the full burst is available again
+	ResetAfter time.Duration
+}
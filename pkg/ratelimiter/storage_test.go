@@ -0,0 +1,353 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runStorageTest runs test against a MemoryStorage and against a
+// RedisStorage on an embedded Redis, each with a clock moved by advance
+func runStorageTest(t *testing.T, test func(t *testing.T, storage Storage, advance func(time.Duration))) {
+	t.Run("memory", func(t *testing.T) {
+		storage, clock := newTestMemoryStorage(t)
+		test(t, storage, clock.Advance)
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		storage, _, advance := newTestRedisStorage(t)
+		test(t, storage, advance)
+	})
+}
+
+func TestStorage_IncrementHonoursWindow(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		for i := int64(1); i <= 3; i++ {
+			count, err := storage.Increment(ctx, "ip:192.168.1.1", time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, i, count)
+		}
+
+		advance(999 * time.Millisecond)
+		count, _ := storage.Get(ctx, "ip:192.168.1.1")
+		assert.Equal(t, int64(3), count)
+
+		advance(time.Millisecond)
+		count, _ = storage.Get(ctx, "ip:192.168.1.1")
+		assert.Equal(t, int64(0), count)
+
+		count, err := storage.Increment(ctx, "ip:192.168.1.1", time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestStorage_BlockExpires(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		assert.NoError(t, storage.SetBlock(ctx, "ip:192.168.1.1", 10*time.Second))
+
+		blocked, err := storage.IsBlocked(ctx, "ip:192.168.1.1")
+		assert.NoError(t, err)
+		assert.True(t, blocked)
+
+		blocked, _ = storage.IsBlocked(ctx, "ip:192.168.1.2")
+		assert.False(t, blocked)
+
+		advance(4 * time.Second)
+		ttl, err := storage.BlockTTL(ctx, "ip:192.168.1.1")
+		assert.NoError(t, err)
+		assert.Equal(t, 6*time.Second, ttl)
+
+		advance(6 * time.Second)
+		blocked, _ = storage.IsBlocked(ctx, "ip:192.168.1.1")
+		assert.False(t, blocked)
+
+		ttl, _ = storage.BlockTTL(ctx, "ip:192.168.1.1")
+		assert.Zero(t, ttl)
+	})
+}
+
+func TestStorage_Hit(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		counters := []Counter{
+			{Key: "ip:192.168.1.1:1s:0", Window: time.Second, Limit: 2},
+			{Key: "ip:192.168.1.1:1m0s:0", Window: time.Minute, Limit: 10},
+		}
+
+		for i := int64(1); i <= 2; i++ {
+			result, err := storage.Hit(ctx, "ip:192.168.1.1", counters, 10*time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{i, i}, result.Counts)
+			assert.False(t, result.Blocked)
+		}
+
+		// Exceeding any counter blocks the key in the same operation
+		result, _ := storage.Hit(ctx, "ip:192.168.1.1", counters, 10*time.Second)
+		assert.Equal(t, []int64{3, 3}, result.Counts)
+		assert.True(t, result.Blocked)
+		assert.Equal(t, 10*time.Second, result.BlockTTL)
+
+		// While blocked nothing is counted and the real remaining TTL is reported
+		advance(4 * time.Second)
+		result, _ = storage.Hit(ctx, "ip:192.168.1.1", counters, 10*time.Second)
+		assert.Empty(t, result.Counts)
+		assert.True(t, result.Blocked)
+		assert.Equal(t, 6*time.Second, result.BlockTTL)
+
+		// The rejected hit was not counted by any counter
+		count, _ := storage.Get(ctx, "ip:192.168.1.1:1m0s:0")
+		assert.Equal(t, int64(2), count)
+	})
+}
+
+func TestStorage_HitRejectedNotCounted(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		// Without a block, a client retrying over its per-second limit keeps
+		// its hourly quota for the requests that get through
+		for second := int64(0); second < 3; second++ {
+			counters := []Counter{
+				{Key: fmt.Sprintf("ip:192.168.1.1:1s:%d", second), Window: time.Second, Limit: 2},
+				{Key: "ip:192.168.1.1:1h0m0s:0", Window: time.Hour, Limit: 10},
+			}
+			for i := 0; i < 20; i++ {
+				storage.Hit(ctx, "ip:192.168.1.1", counters, 0)
+			}
+			advance(time.Second)
+		}
+
+		count, _ := storage.Get(ctx, "ip:192.168.1.1:1h0m0s:0")
+		assert.Equal(t, int64(6), count)
+	})
+}
+
+func TestStorage_AddRequestSlidesAcrossBoundary(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		logs := []Counter{{Key: "ip:192.168.1.1:1s", Window: time.Second, Limit: 10}}
+
+		// Burst at the end of one second...
+		advance(900 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			storage.AddRequest(ctx, "ip:192.168.1.1", logs)
+		}
+
+		// ...still counts right after the boundary
+		advance(200 * time.Millisecond)
+		counts, err := storage.AddRequest(ctx, "ip:192.168.1.1", logs)
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{6}, counts)
+
+		// Once the burst is a full window old only the later request remains
+		advance(800 * time.Millisecond)
+		counts, _ = storage.AddRequest(ctx, "ip:192.168.1.1", logs)
+		assert.Equal(t, []int64{2}, counts)
+	})
+}
+
+func TestStorage_AddRequestSkipsRejected(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		logs := []Counter{
+			{Key: "ip:192.168.1.1:1s", Window: time.Second, Limit: 2},
+			{Key: "ip:192.168.1.1:1h0m0s", Window: time.Hour, Limit: 10},
+		}
+
+		for i := int64(1); i <= 4; i++ {
+			counts, err := storage.AddRequest(ctx, "ip:192.168.1.1", logs)
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{min(i, 3), min(i, 3)}, counts)
+		}
+
+		// Only the two allowed requests took room in the logs, so a client
+		// retrying over its limit gets through as soon as they slide out
+		advance(time.Second)
+		counts, _ := storage.AddRequest(ctx, "ip:192.168.1.1", logs)
+		assert.Equal(t, []int64{1, 3}, counts)
+	})
+}
+
+func TestStorage_TakeTokenRefills(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		buckets := []Bucket{{Key: "token:abc123:1s", Burst: 5, Interval: 100 * time.Millisecond}}
+
+		// A new bucket starts full and allows a burst up to its capacity
+		for i := int64(4); i >= 0; i-- {
+			states, err := storage.TakeToken(ctx, "token:abc123", buckets)
+			assert.NoError(t, err)
+			assert.True(t, states[0].Allowed)
+			assert.Equal(t, i, states[0].Tokens)
+		}
+
+		states, _ := storage.TakeToken(ctx, "token:abc123", buckets)
+		assert.False(t, states[0].Allowed)
+		assert.Equal(t, 100*time.Millisecond, states[0].NextToken)
+
+		advance(40 * time.Millisecond)
+		states, _ = storage.TakeToken(ctx, "token:abc123", buckets)
+		assert.False(t, states[0].Allowed)
+		assert.Equal(t, 60*time.Millisecond, states[0].NextToken)
+
+		// Refills at one token per interval, never above capacity
+		advance(260 * time.Millisecond)
+		states, _ = storage.TakeToken(ctx, "token:abc123", buckets)
+		assert.True(t, states[0].Allowed)
+		assert.Equal(t, int64(2), states[0].Tokens)
+
+		advance(time.Hour)
+		states, _ = storage.TakeToken(ctx, "token:abc123", buckets)
+		assert.True(t, states[0].Allowed)
+		assert.Equal(t, int64(4), states[0].Tokens)
+	})
+}
+
+func TestStorage_TakeTokenFromEveryBucket(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		buckets := []Bucket{
+			{Key: "token:abc123:1s", Burst: 2, Interval: 500 * time.Millisecond},
+			{Key: "token:abc123:1h0m0s", Burst: 10, Interval: 360 * time.Millisecond},
+		}
+
+		for i := 0; i < 20; i++ {
+			storage.TakeToken(ctx, "token:abc123", buckets)
+		}
+
+		// An empty bucket keeps the tokens of the others
+		states, err := storage.TakeToken(ctx, "token:abc123", buckets)
+		assert.NoError(t, err)
+		assert.False(t, states[0].Allowed)
+		assert.True(t, states[1].Allowed)
+		assert.Equal(t, int64(8), states[1].Tokens)
+	})
+}
+
+func TestStorage_ApplyGCRA(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		buckets := []Bucket{{Key: "ip:192.168.1.1:1s", Burst: 3, Interval: 100 * time.Millisecond}}
+
+		// Up to burst requests conform at once
+		for i := int64(2); i >= 0; i-- {
+			states, err := storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+			assert.NoError(t, err)
+			assert.True(t, states[0].Allowed)
+			assert.Equal(t, i, states[0].Remaining)
+		}
+
+		states, _ := storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+		assert.False(t, states[0].Allowed)
+		assert.Equal(t, 100*time.Millisecond, states[0].RetryAfter)
+		assert.Equal(t, 300*time.Millisecond, states[0].ResetAfter)
+
+		// The next request conforms exactly when RetryAfter elapses
+		advance(99 * time.Millisecond)
+		states, _ = storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+		assert.False(t, states[0].Allowed)
+		assert.Equal(t, time.Millisecond, states[0].RetryAfter)
+
+		advance(time.Millisecond)
+		states, _ = storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+		assert.True(t, states[0].Allowed)
+		assert.Equal(t, int64(0), states[0].Remaining)
+	})
+}
+
+func TestStorage_ApplyGCRAToEveryArrivalTime(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+		buckets := []Bucket{
+			{Key: "ip:192.168.1.1:1s", Burst: 2, Interval: 500 * time.Millisecond},
+			{Key: "ip:192.168.1.1:1h0m0s", Burst: 10, Interval: 360 * time.Millisecond},
+		}
+
+		for i := 0; i < 20; i++ {
+			storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+		}
+
+		// Requests rejected by the first limit were not charged to the second
+		states, err := storage.ApplyGCRA(ctx, "ip:192.168.1.1", buckets)
+		assert.NoError(t, err)
+		assert.False(t, states[0].Allowed)
+		assert.True(t, states[1].Allowed)
+		assert.Equal(t, int64(7), states[1].Remaining)
+	})
+}
+
+func TestStorage_UnblockAndReset(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		for _, key := range []string{"ip:192.168.1.1", "ip:192.168.1.10"} {
+			useEveryAlgorithm(ctx, storage, key)
+		}
+		storage.SetBlock(ctx, "ip:192.168.1.1", time.Minute)
+		storage.SetBlock(ctx, "ip:192.168.1.10", 2*time.Minute)
+		storage.SetBlock(ctx, "ip:192.168.1.2", time.Second)
+
+		advance(time.Second)
+		blocked, err := storage.BlockedKeys(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []BlockedKey{
+			{Key: "ip:192.168.1.1", TTL: 59 * time.Second},
+			{Key: "ip:192.168.1.10", TTL: 119 * time.Second},
+		}, blocked)
+
+		assert.NoError(t, storage.Unblock(ctx, "ip:192.168.1.10"))
+		blocked, _ = storage.BlockedKeys(ctx)
+		assert.Equal(t, []BlockedKey{{Key: "ip:192.168.1.1", TTL: 59 * time.Second}}, blocked)
+
+		// Reset clears the key and its limits, but not keys sharing its prefix
+		assert.NoError(t, storage.Reset(ctx, "ip:192.168.1.1"))
+		blocked, _ = storage.BlockedKeys(ctx)
+		assert.Empty(t, blocked)
+		assert.Equal(t, 1, useEveryAlgorithm(ctx, storage, "ip:192.168.1.1"))
+		assert.Equal(t, 2, useEveryAlgorithm(ctx, storage, "ip:192.168.1.10"))
+	})
+}
+
+func TestStorage_ResetIPv6(t *testing.T) {
+	runStorageTest(t, func(t *testing.T, storage Storage, advance func(time.Duration)) {
+		ctx := context.Background()
+
+		// The keys of ip:2001:db8::1:5 start with ip:2001:db8::1 and a colon too
+		for _, key := range []string{"ip:2001:db8::1", "ip:2001:db8::1:5"} {
+			useEveryAlgorithm(ctx, storage, key)
+		}
+
+		assert.NoError(t, storage.Reset(ctx, "ip:2001:db8::1"))
+		assert.Equal(t, 1, useEveryAlgorithm(ctx, storage, "ip:2001:db8::1"))
+		assert.Equal(t, 2, useEveryAlgorithm(ctx, storage, "ip:2001:db8::1:5"))
+	})
+}
+
+// useEveryAlgorithm counts a request for key, which must not be blocked,
+// with every algorithm and returns how many requests each of them has seen,
+// or -1 when they disagree
+func useEveryAlgorithm(ctx context.Context, storage Storage, key string) int {
+	bucket := []Bucket{{Key: key + ":1s", Burst: 10, Interval: time.Minute}}
+
+	hit, _ := storage.Hit(ctx, key, []Counter{{Key: key + ":1s:0", Window: time.Minute, Limit: 10}}, 0)
+	logs, _ := storage.AddRequest(ctx, key, []Counter{{Key: key + ":1s", Window: time.Minute, Limit: 10}})
+	tokens, _ := storage.TakeToken(ctx, key, bucket)
+	tats, _ := storage.ApplyGCRA(ctx, key, bucket)
+
+	seen := []int64{hit.Counts[0], logs[0], 10 - tokens[0].Tokens, 10 - tats[0].Remaining}
+	for _, count := range seen[1:] {
+		if count != seen[0] {
+			return -1
+		}
+	}
+	return int(seen[0])
+}
@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TokenRegistry looks up the API tokens known to the rate limiter
+type TokenRegistry interface {
+	// Lookup returns the limits of token and whether it is known.
+	// A known token without limits of its own gets the default token limits.
+	Lookup(ctx context.Context, token string) ([]Limit, bool, error)
+}
+
+// StaticTokenRegistry is a TokenRegistry backed by a fixed map of token limits
+type StaticTokenRegistry map[string][]Limit
+
+// Lookup returns the limits of token and whether it is in the map
+func (r StaticTokenRegistry) Lookup(ctx context.Context, token string) ([]Limit, bool, error) {
+	limits, exists := r[token]
+	return limits, exists, nil
+}
+
+// KnownTokens wraps a TokenRegistry so that its tokens are still recognised
+// but get the default token limits, e.g. within a route policy
+type KnownTokens struct {
+	TokenRegistry
+}
+
+// Lookup reports whether token is known, without its limits
+func (k KnownTokens) Lookup(ctx context.Context, token string) ([]Limit, bool, error) {
+	_, known, err := k.TokenRegistry.Lookup(ctx, token)
+	return nil, known, err
+}
+
+// UnknownTokenPolicy selects how requests with a token that is not in the
+// registry are limited
+type UnknownTokenPolicy string
+
+const (
+	// UnknownTokenDefault limits unknown tokens by the default token limits.
+	// It is the default.
+	UnknownTokenDefault UnknownTokenPolicy = "default"
+
+	// UnknownTokenIP ignores unknown tokens and limits the request by IP
+	UnknownTokenIP UnknownTokenPolicy = "ip"
+
+	// UnknownTokenReject rejects requests with an unknown token with ErrUnknownToken
+	UnknownTokenReject UnknownTokenPolicy = "reject"
+)
+
+var (
+	// ErrUnknownToken is returned by CheckLimit for a token that is not in
+	// the registry when unknown tokens are rejected
+	ErrUnknownToken = errors.New("unknown API token")
+
+	// ErrUnknownTokenPolicy is returned when an unknown token policy name is not recognised
+	ErrUnknownTokenPolicy = errors.New("unknown token policy")
+)
+
+// ParseUnknownTokenPolicy converts a policy name into an UnknownTokenPolicy.
+// An empty name selects UnknownTokenDefault.
+func ParseUnknownTokenPolicy(name string) (UnknownTokenPolicy, error) {
+	switch UnknownTokenPolicy(name) {
+	case "", UnknownTokenDefault:
+		return UnknownTokenDefault, nil
+	case UnknownTokenIP:
+		return UnknownTokenIP, nil
+	case UnknownTokenReject:
+		return UnknownTokenReject, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownTokenPolicy, name)
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"errors"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the CheckLimit spans
+const tracerName = "github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
+
+// annotateSpan describes the decision of CheckLimit in its span. Keys are
+// reduced to their type so that tokens and client IPs are not exported.
+func (rl *RateLimiter) annotateSpan(span trace.Span, result *LimitResult, err error) {
+	if !span.IsRecording() {
+		return
+	}
+
+	if rl.namespace != "" {
+		span.SetAttributes(attribute.String("ratelimiter.namespace", rl.namespace))
+	}
+
+	switch {
+	case errors.Is(err, ErrUnknownToken):
+		span.SetAttributes(
+			attribute.String("ratelimiter.key_type", "token"),
+			attribute.String("ratelimiter.decision", "unknown_token"),
+		)
+		return
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	case result.Reason != "":
+		span.SetAttributes(
+			attribute.String("ratelimiter.key_type", "ip"),
+			attribute.String("ratelimiter.decision", string(result.Reason)),
+		)
+		return
+	}
+
+	decision := "rejected"
+	if result.Allowed {
+		decision = "allowed"
+	}
+
+	keyType, _, _ := strings.Cut(strings.TrimPrefix(result.Key, rl.namespace+":"), ":")
+	span.SetAttributes(
+		attribute.String("ratelimiter.key_type", keyType),
+		attribute.String("ratelimiter.decision", decision),
+		attribute.Int("ratelimiter.limit", result.Limit),
+		attribute.Int("ratelimiter.remaining", result.Remaining),
+		attribute.Bool("ratelimiter.blocked", result.Blocked),
+	)
+}
@@ -2,79 +2,192 @@ package test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/admin"
 	"github.com/danilotorchio/go-expert-rate-limiter/internal/middleware"
+	"github.com/danilotorchio/go-expert-rate-limiter/internal/tracing"
 	"github.com/danilotorchio/go-expert-rate-limiter/pkg/ratelimiter"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-// InMemoryStorage is a simple in-memory implementation for testing
-type InMemoryStorage struct {
-	counts  map[string]int64
-	blocked map[string]time.Time
+// routerSetup is what newTestRouter builds the router from
+type routerSetup struct {
+	config     ratelimiter.Config
+	storage    func(storage ratelimiter.Storage) ratelimiter.Storage
+	policies   func(storage ratelimiter.Storage) []middleware.Policy
+	middleware []middleware.Option
+	admin      []admin.Option
+	withAdmin  bool
+	routes     []route
 }
 
-func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{
-		counts:  make(map[string]int64),
-		blocked: make(map[string]time.Time),
+type route struct {
+	method, path string
+	handler      gin.HandlerFunc
+}
+
+// routerOption changes the defaults of newTestRouter
+type routerOption func(setup *routerSetup)
+
+// withConfig changes the rate limiter config
+func withConfig(change func(config *ratelimiter.Config)) routerOption {
+	return func(setup *routerSetup) {
+		change(&setup.config)
 	}
 }
 
-func (i *InMemoryStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
-	i.counts[key]++
-	return i.counts[key], nil
+// withStorage wraps the memory storage given to the rate limiter
+func withStorage(wrap func(storage ratelimiter.Storage) ratelimiter.Storage) routerOption {
+	return func(setup *routerSetup) {
+		setup.storage = wrap
+	}
 }
 
-func (i *InMemoryStorage) Get(ctx context.Context, key string) (int64, error) {
-	return i.counts[key], nil
+// withPolicies adds route policies, whose limiters share the memory storage
+func withPolicies(policies func(storage ratelimiter.Storage) []middleware.Policy) routerOption {
+	return func(setup *routerSetup) {
+		setup.policies = policies
+	}
 }
 
-func (i *InMemoryStorage) SetBlock(ctx context.Context, key string, duration time.Duration) error {
-	i.blocked[key] = time.Now().Add(duration)
-	return nil
+// withMiddleware passes options to the rate limiter middleware
+func withMiddleware(opts ...middleware.Option) routerOption {
+	return func(setup *routerSetup) {
+		setup.middleware = append(setup.middleware, opts...)
+	}
 }
 
-func (i *InMemoryStorage) IsBlocked(ctx context.Context, key string) (bool, error) {
-	if blockedUntil, exists := i.blocked[key]; exists {
-		if time.Now().Before(blockedUntil) {
-			return true, nil
-		}
-		delete(i.blocked, key)
+// withAdmin registers the admin API under /admin with the token "secret"
+func withAdmin(opts ...admin.Option) routerOption {
+	return func(setup *routerSetup) {
+		setup.withAdmin = true
+		setup.admin = opts
 	}
-	return false, nil
 }
 
-func (i *InMemoryStorage) Close() error {
-	return nil
+// withRoute adds a route answering with success
+func withRoute(method, path string) routerOption {
+	return withHandler(method, path, succeed)
+}
+
+// withHandler adds a route served by handler
+func withHandler(method, path string, handler gin.HandlerFunc) routerOption {
+	return func(setup *routerSetup) {
+		setup.routes = append(setup.routes, route{method: method, path: path, handler: handler})
+	}
+}
+
+func succeed(c *gin.Context) {
+	c.JSON(200, gin.H{"message": "success"})
+}
+
+func setupTestRouter() (*gin.Engine, *ratelimiter.MemoryStorage) {
+	storage := ratelimiter.NewMemoryStorage(time.Minute)
+	return buildTestRouter(storage), storage
+}
+
+// newTestRouter is setupTestRouter with options, on a memory storage closed
+// with the test
+func newTestRouter(t *testing.T, opts ...routerOption) *gin.Engine {
+	storage := ratelimiter.NewMemoryStorage(time.Minute)
+	t.Cleanup(func() { storage.Close() })
+	
+	return buildTestRouter(storage, opts...)
 }
 
-func setupTestRouter() (*gin.Engine, *InMemoryStorage) {
+// buildTestRouter builds a router limiting GET /test and the routes of its
+// options with a rate limiter on memory
+func buildTestRouter(memory *ratelimiter.MemoryStorage, opts ...routerOption) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	
-	storage := NewInMemoryStorage()
-	config := ratelimiter.Config{
-		DefaultIPLimit:    3, // Low limit for testing
-		DefaultTokenLimit: 5,
-		BlockDuration:     10 * time.Second,
-		TokenLimits:       map[string]int{"test_token": 2},
+	setup := &routerSetup{
+		config: ratelimiter.Config{
+			// Low limits for testing, per minute so a test never straddles a window boundary
+			DefaultIPLimits:    []ratelimiter.Limit{ratelimiter.PerMinute(3)},
+			DefaultTokenLimits: []ratelimiter.Limit{ratelimiter.PerMinute(5)},
+			BlockDuration:      10 * time.Second,
+			TokenLimits:        map[string][]ratelimiter.Limit{"test_token": {ratelimiter.PerMinute(2)}},
+			IdentityMode:       ratelimiter.IdentityToken,
+		},
+		routes: []route{{method: http.MethodGet, path: "/test", handler: succeed}},
+	}
+	for _, opt := range opts {
+		opt(setup)
 	}
 	
-	rateLimiter := ratelimiter.New(storage, config)
+	var storage ratelimiter.Storage = memory
+	if setup.storage != nil {
+		storage = setup.storage(storage)
+	}
+	if setup.policies != nil {
+		setup.middleware = append(setup.middleware, middleware.WithPolicies(setup.policies(storage)...))
+	}
+	
+	rateLimiter := ratelimiter.New(storage, setup.config)
 	
 	router := gin.New()
-	router.Use(middleware.RateLimiterMiddleware(rateLimiter))
+	if setup.withAdmin {
+		admin.Register(router.Group("/admin"), rateLimiter, "secret", setup.admin...)
+	}
+	router.Use(middleware.RateLimiterMiddleware(rateLimiter, setup.middleware...))
 	
-	router.GET("/test", func(c *gin.Context) {
-		c.JSON(200, gin.H{"message": "success"})
-	})
+	for _, route := range setup.routes {
+		router.Handle(route.method, route.path, route.handler)
+	}
 	
-	return router, storage
+	return router
+}
+
+// requestOption changes a request sent by send
+type requestOption func(req *http.Request)
+
+// fromAddr sends the request from addr instead of 192.168.1.1:12345
+func fromAddr(addr string) requestOption {
+	return func(req *http.Request) {
+		req.RemoteAddr = addr
+	}
+}
+
+// withHeader sets a header of the request
+func withHeader(name, value string) requestOption {
+	return func(req *http.Request) {
+		req.Header.Set(name, value)
+	}
+}
+
+// withBody sets the body of the request
+func withBody(body string) requestOption {
+	return func(req *http.Request) {
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+}
+
+// send serves a request from 192.168.1.1 through router
+func send(router *gin.Engine, method, path string, opts ...requestOption) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.168.1.1:12345"
+	for _, opt := range opts {
+		opt(req)
+	}
+	
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	
+	return w
 }
 
 func TestIntegration_IPBasedRateLimit(t *testing.T) {
@@ -165,6 +278,312 @@ func TestIntegration_TokenPriorityOverIP(t *testing.T) {
 	storage.Close()
 }
 
+func TestIntegration_IPAndTokenCombined(t *testing.T) {
+	router := newTestRouter(t, withConfig(func(config *ratelimiter.Config) {
+		config.IdentityMode = ratelimiter.IdentityIPAndToken
+	}))
+	
+	// The IP limit of 3 applies even with a token whose own limit is higher
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send(router, "GET", "/test", withHeader("API_KEY", "some_token")).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test", withHeader("API_KEY", "some_token")).Code)
+	
+	// A blocked IP cannot get through by sending another token
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test", withHeader("API_KEY", "random_token")).Code)
+}
+
+func TestIntegration_UnknownTokenRejected(t *testing.T) {
+	router := newTestRouter(t, withConfig(func(config *ratelimiter.Config) {
+		config.UnknownTokens = ratelimiter.UnknownTokenReject
+	}))
+	
+	assert.Equal(t, http.StatusUnauthorized, send(router, "GET", "/test", withHeader("API_KEY", "invented_token")).Code)
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test", withHeader("API_KEY", "test_token")).Code)
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+}
+
+func TestIntegration_AccessList(t *testing.T) {
+	router := newTestRouter(t, withConfig(func(config *ratelimiter.Config) {
+		config.DefaultIPLimits = []ratelimiter.Limit{ratelimiter.PerMinute(1)}
+		config.AccessList = ratelimiter.NewAccessList(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			[]netip.Prefix{netip.MustParsePrefix("192.168.6.0/24")},
+		)
+	}))
+	
+	// Allowlisted IPs are never limited
+	for i := 0; i < 5; i++ {
+		w := send(router, "GET", "/test", fromAddr("10.0.0.1:12345"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Remaining"))
+	}
+	
+	// Denylisted IPs are always forbidden
+	w := send(router, "GET", "/test", fromAddr("192.168.6.6:12345"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "access denied")
+	
+	// Other IPs keep their limits
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+}
+
+func TestIntegration_RateLimitHeaders(t *testing.T) {
+	router := newTestRouter(t, withConfig(func(config *ratelimiter.Config) {
+		config.DefaultIPLimits = []ratelimiter.Limit{ratelimiter.PerMinute(2)}
+	}), withMiddleware(middleware.WithStandardHeaders()))
+	
+	w := send(router, "GET", "/test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, `"default";q=2;w=60`, w.Header().Get("RateLimit-Policy"))
+	assert.Regexp(t, `^"default";r=1;t=\d+$`, w.Header().Get("RateLimit"))
+	
+	reset, err := time.Parse(time.RFC3339, w.Header().Get("X-RateLimit-Reset"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.UTC, reset.Location())
+	
+	send(router, "GET", "/test")
+	w = send(router, "GET", "/test")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+}
+
+func TestIntegration_ShadowMode(t *testing.T) {
+	router := newTestRouter(t,
+		withConfig(func(config *ratelimiter.Config) {
+			config.DefaultIPLimits = []ratelimiter.Limit{ratelimiter.PerMinute(1)}
+		}),
+		withRoute("POST", "/test"),
+		withPolicies(func(storage ratelimiter.Storage) []middleware.Policy {
+			writeLimiter := ratelimiter.New(storage, ratelimiter.Config{
+				DefaultIPLimits: []ratelimiter.Limit{ratelimiter.PerMinute(1)},
+				BlockDuration:   10 * time.Second,
+				Namespace:       "policy:write",
+			})
+			return []middleware.Policy{
+				{Method: http.MethodPost, Path: "/test", Limiter: writeLimiter, Shadow: true},
+			}
+		}),
+	)
+	
+	// The shadow policy lets would-be rejections through and marks them
+	w := send(router, "POST", "/test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-RateLimit-Shadow"))
+	
+	w = send(router, "POST", "/test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "exceeded", w.Header().Get("X-RateLimit-Shadow"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+	
+	// Routes outside the shadow policy are still enforced
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+}
+
+func TestIntegration_ShadowPolicyWithoutLimiter(t *testing.T) {
+	var storage ratelimiter.Storage
+	router := newTestRouter(t,
+		withRoute("POST", "/test"),
+		withStorage(func(memory ratelimiter.Storage) ratelimiter.Storage {
+			storage = memory
+			return memory
+		}),
+		withPolicies(func(storage ratelimiter.Storage) []middleware.Policy {
+			return []middleware.Policy{{Method: http.MethodPost, Path: "/test", Shadow: true}}
+		}),
+	)
+	
+	// Exceeding the default limits on the shadow route does not block the client...
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, send(router, "POST", "/test").Code)
+	}
+	
+	blocked, err := storage.IsBlocked(context.Background(), "ip:192.168.1.1")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	
+	// ...though its requests still count on the enforced routes
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+}
+
+func TestIntegration_AdminAPI(t *testing.T) {
+	router := newTestRouter(t, withAdmin(), withConfig(func(config *ratelimiter.Config) {
+		config.DefaultIPLimits = []ratelimiter.Limit{ratelimiter.PerMinute(1)}
+		config.BlockDuration = time.Minute
+	}))
+	asAdmin := withHeader("Authorization", "Bearer secret")
+	
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+	
+	// The admin API requires the token and is not rate limited itself
+	assert.Equal(t, http.StatusUnauthorized, send(router, "GET", "/admin/blocks").Code)
+	assert.Equal(t, http.StatusUnauthorized, send(router, "GET", "/admin/blocks", withHeader("Authorization", "Bearer wrong")).Code)
+	assert.Equal(t, http.StatusBadRequest, send(router, "GET", "/admin/keys", asAdmin).Code)
+	
+	w := send(router, "GET", "/admin/blocks", asAdmin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"blocked":[{"key":"ip:192.168.1.1","ttl_seconds":60}]}`, w.Body.String())
+	
+	w = send(router, "GET", "/admin/keys?key=ip:192.168.1.1", asAdmin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	
+	var state struct {
+		Blocked         bool  `json:"blocked"`
+		BlockTTLSeconds int64 `json:"block_ttl_seconds"`
+		Windows         []struct {
+			Limit int   `json:"limit"`
+			Count int64 `json:"count"`
+		} `json:"windows"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.True(t, state.Blocked)
+	assert.Equal(t, int64(60), state.BlockTTLSeconds)
+	assert.Len(t, state.Windows, 1)
+	assert.Equal(t, 1, state.Windows[0].Limit)
+	assert.Equal(t, int64(1), state.Windows[0].Count)
+	
+	// Unblocking keeps the counter, so the next request blocks again
+	assert.Equal(t, http.StatusNoContent, send(router, "DELETE", "/admin/blocks?key=ip:192.168.1.1", asAdmin).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+	
+	// Resetting clears the counter as well
+	assert.Equal(t, http.StatusNoContent, send(router, "DELETE", "/admin/keys?key=ip:192.168.1.1", asAdmin).Code)
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+}
+
+func TestIntegration_AdminTokens(t *testing.T) {
+	registry, err := ratelimiter.NewManagedTokenRegistry(context.Background(), nil, map[string][]ratelimiter.Limit{
+		"test_token": {ratelimiter.PerMinute(2)},
+	})
+	assert.NoError(t, err)
+	defer registry.Close()
+	
+	router := newTestRouter(t, withAdmin(admin.WithTokenRegistry(registry)), withConfig(func(config *ratelimiter.Config) {
+		config.TokenRegistry = registry
+		config.UnknownTokens = ratelimiter.UnknownTokenReject
+	}))
+	asAdmin := withHeader("Authorization", "Bearer secret")
+	withToken := withHeader("API_KEY", "new_customer")
+	
+	assert.Equal(t, http.StatusUnauthorized, send(router, "GET", "/test", withToken).Code)
+	
+	// A created token is accepted right away with its own limits
+	w := send(router, "PUT", "/admin/tokens/new_customer", asAdmin, withBody(`{"limits":"1/m"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"token":"new_customer","limits":"1/m"}`, w.Body.String())
+	
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test", withToken).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test", withToken).Code)
+	
+	w = send(router, "GET", "/admin/tokens", asAdmin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"tokens":[{"token":"new_customer","limits":"1/m"},{"token":"test_token","limits":"2/m","static":true}]}`, w.Body.String())
+	
+	// Invalid limits and static tokens are refused
+	assert.Equal(t, http.StatusBadRequest, send(router, "PUT", "/admin/tokens/other", asAdmin, withBody(`{"limits":"ten"}`)).Code)
+	assert.Equal(t, http.StatusConflict, send(router, "PUT", "/admin/tokens/test_token", asAdmin, withBody(`{"limits":"10/s"}`)).Code)
+	assert.Equal(t, http.StatusConflict, send(router, "DELETE", "/admin/tokens/test_token", asAdmin).Code)
+	
+	// A revoked token is unknown again
+	assert.Equal(t, http.StatusNoContent, send(router, "DELETE", "/admin/tokens/new_customer", asAdmin).Code)
+	assert.Equal(t, http.StatusNotFound, send(router, "DELETE", "/admin/tokens/new_customer", asAdmin).Code)
+	assert.Equal(t, http.StatusUnauthorized, send(router, "GET", "/test", withToken).Code)
+}
+
+func TestIntegration_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	
+	var handlerTraceID oteltrace.TraceID
+	router := newTestRouter(t,
+		withConfig(func(config *ratelimiter.Config) {
+			config.TracerProvider = provider
+		}),
+		withStorage(func(storage ratelimiter.Storage) ratelimiter.Storage {
+			return tracing.InstrumentStorage(storage, provider, "memory")
+		}),
+		withMiddleware(middleware.WithTracerProvider(provider)),
+		withHandler("GET", "/traced", func(c *gin.Context) {
+			handlerTraceID = oteltrace.SpanContextFromContext(c.Request.Context()).TraceID()
+			succeed(c)
+		}),
+	)
+	
+	w := send(router, "GET", "/traced", withHeader("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	
+	// The middleware span continues the incoming trace and the limiter and
+	// storage spans nest under it
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		names[span.Name()] = span
+	}
+	assert.Equal(t, "00f067aa0ba902b7", names["ratelimiter.middleware"].Parent().SpanID().String())
+	assert.Equal(t, names["ratelimiter.middleware"].SpanContext().SpanID(), names["ratelimiter.CheckLimit"].Parent().SpanID())
+	assert.Equal(t, names["ratelimiter.CheckLimit"].SpanContext().SpanID(), names["ratelimiter.storage.hit"].Parent().SpanID())
+	
+	// The handlers carry on with the incoming trace as well
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handlerTraceID.String())
+}
+
+// unavailableStorage is a Storage whose rate limit calls fail as if Redis were down
+type unavailableStorage struct {
+	ratelimiter.Storage
+}
+
+func (unavailableStorage) Hit(ctx context.Context, key string, counters []ratelimiter.Counter, blockDuration time.Duration) (*ratelimiter.HitResult, error) {
+	return nil, errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+}
+
+func TestIntegration_FailurePolicy(t *testing.T) {
+	// The memory storage of the router is the local fallback when asked for
+	setup := func(fallback bool, opts ...middleware.Option) *gin.Engine {
+		return newTestRouter(t,
+			withConfig(func(config *ratelimiter.Config) {
+				config.DefaultIPLimits = []ratelimiter.Limit{ratelimiter.PerMinute(1)}
+			}),
+			withStorage(func(storage ratelimiter.Storage) ratelimiter.Storage {
+				breakerConfig := ratelimiter.CircuitBreakerConfig{}
+				if fallback {
+					breakerConfig.Fallback = storage
+				}
+				return ratelimiter.NewCircuitBreakerStorage(unavailableStorage{}, breakerConfig)
+			}),
+			withMiddleware(opts...),
+		)
+	}
+	
+	// Fail closed refuses requests while the storage is down
+	router := setup(false)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusServiceUnavailable, send(router, "GET", "/test").Code)
+	}
+	
+	// Fail open lets them through unlimited
+	router = setup(false, middleware.WithFailOpen())
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+	}
+	
+	// The local fallback keeps enforcing the limits on its own
+	router = setup(true)
+	assert.Equal(t, http.StatusOK, send(router, "GET", "/test").Code)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/test").Code)
+	}
+}
+
 func TestIntegration_DifferentIPs(t *testing.T) {
 	router, _ := setupTestRouter()
 	
@@ -192,4 +611,39 @@ func TestIntegration_DifferentIPs(t *testing.T) {
 		
 		assert.Equal(t, http.StatusTooManyRequests, w.Code)
 	}
-} 
\ No newline at end of file
+}
+
+func TestIntegration_RoutePolicies(t *testing.T) {
+	router := newTestRouter(t,
+		withRoute("GET", "/api/:id"),
+		withRoute("POST", "/api/:id"),
+		withRoute("GET", "/health"),
+		withPolicies(func(storage ratelimiter.Storage) []middleware.Policy {
+			writeLimiter := ratelimiter.New(storage, ratelimiter.Config{
+				DefaultIPLimits:    []ratelimiter.Limit{ratelimiter.PerMinute(1)},
+				DefaultTokenLimits: []ratelimiter.Limit{ratelimiter.PerMinute(1)},
+				BlockDuration:      10 * time.Second,
+				Namespace:          "policy:write",
+			})
+			return []middleware.Policy{
+				{Method: http.MethodPost, Path: "/api/:id", Limiter: writeLimiter},
+				{Path: "/health", Exempt: true},
+			}
+		}),
+	)
+	
+	// The POST policy matches the route template, whatever the id
+	assert.Equal(t, http.StatusOK, send(router, "POST", "/api/1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "POST", "/api/2").Code)
+	
+	// GET on the same route keeps the default limit and its own counters
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, send(router, "GET", "/api/1").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, send(router, "GET", "/api/1").Code)
+	
+	// Exempt routes are never limited
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, send(router, "GET", "/health").Code)
+	}
+}